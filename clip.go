@@ -0,0 +1,169 @@
+// 从正在下载的录播剪辑片段相关
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Mrs4s/MiraiGo/client"
+	"github.com/Mrs4s/MiraiGo/message"
+)
+
+// 剪辑的最长时长（分钟）
+const clipMaxMinutes = 60
+
+// 剪辑文件不超过这个大小时通过 QQ 上传
+const clipUploadMaxSize = 100 * 1024 * 1024
+
+// 剪辑的片段
+type clipResult struct {
+	UID      int     `json:"uid"`      // 主播 uid
+	Name     string  `json:"name"`     // 主播名字
+	LiveID   string  `json:"liveID"`   // 直播 ID
+	File     string  `json:"file"`     // 剪辑文件路径
+	Start    float64 `json:"start"`    // 片段在录播里的开始时间，单位为秒
+	Duration float64 `json:"duration"` // 片段的时长，单位为秒
+	Size     int64   `json:"size"`     // 剪辑文件的大小（字节）
+}
+
+// 无损剪辑指定主播正在下载的录播的最后 minutes 分钟，录播不够长时剪辑整个录播
+func clip(uid, minutes int) (*clipResult, error) {
+	if minutes <= 0 || minutes > clipMaxMinutes {
+		return nil, fmt.Errorf("剪辑的时长必须在 1 到 %d 分钟之间", clipMaxMinutes)
+	}
+	infoList, ok := getLiveInfoByUID(uid)
+	if !ok {
+		return nil, fmt.Errorf("没有在下载%s的直播视频", longID(uid))
+	}
+	var info liveInfo
+	var rec *recording
+	for _, i := range infoList {
+		if r, ok := i.recordings[""]; ok && i.isRecording {
+			info, rec = i, r
+			break
+		}
+	}
+	if rec == nil {
+		return nil, fmt.Errorf("没有在下载%s的直播视频", longID(uid))
+	}
+	if !isFileExist(rec.recordFile) {
+		return nil, fmt.Errorf("录播文件 %s 不存在", rec.recordFile)
+	}
+
+	// 录播文件还在增长，往前留一点时间防止读到还没写完的数据
+	elapsed := partDuration(rec, rec.start) - 2*time.Second
+	length := time.Duration(minutes) * time.Minute
+	start := elapsed - length
+	if start < 0 {
+		lPrintWarnf("%s正在下载的录播不足%d分钟，剪辑整个录播", longID(uid), minutes)
+		start, length = 0, elapsed
+	}
+	if length <= 0 {
+		return nil, fmt.Errorf("%s正在下载的录播太短，无法剪辑", longID(uid))
+	}
+
+	ext := filepath.Ext(rec.recordFile)
+	file := strings.TrimSuffix(rec.recordFile, ext) + "_clip_" + time.Now().Format("150405") + ext
+	lPrintf("开始剪辑%s正在下载的录播的最后%d分钟", longID(uid), minutes)
+	if err := runFFmpeg(
+		"-ss", strconv.FormatFloat(start.Seconds(), 'f', 3, 64),
+		"-i", rec.recordFile,
+		"-t", strconv.FormatFloat(length.Seconds(), 'f', 3, 64),
+		"-c", "copy",
+		file,
+	); err != nil {
+		_ = os.Remove(file)
+		return nil, err
+	}
+	stat, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+	if stat.Size() == 0 {
+		_ = os.Remove(file)
+		return nil, fmt.Errorf("剪辑的文件 %s 为空", file)
+	}
+	if abs, err := filepath.Abs(file); err == nil {
+		file = abs
+	}
+	lPrintln("剪辑的文件保存在" + file)
+
+	return &clipResult{
+		UID:      uid,
+		Name:     getName(uid),
+		LiveID:   info.LiveID,
+		File:     file,
+		Start:    start.Seconds(),
+		Duration: length.Seconds(),
+		Size:     stat.Size(),
+	}, nil
+}
+
+// 解析 clip 命令的参数 uid 和 minutes
+func parseClipArgs(args []string) (uid, minutes int, ok bool) {
+	if len(args) != 2 {
+		return 0, 0, false
+	}
+	uid, err1 := strconv.Atoi(args[0])
+	minutes, err2 := strconv.Atoi(args[1])
+	if err1 != nil || err2 != nil || uid <= 0 {
+		return 0, 0, false
+	}
+	return uid, minutes, true
+}
+
+// 处理 clip 命令，返回 json 格式的剪辑结果
+func handleClip(args []string) string {
+	uid, minutes, ok := parseClipArgs(args)
+	if !ok {
+		printErr()
+		return ""
+	}
+	c, err := clip(uid, minutes)
+	if err != nil {
+		lPrintErrf("剪辑失败：%v", err)
+		return ""
+	}
+	data, err := json.MarshalIndent(c, "", "    ")
+	checkErr(err)
+	return string(data)
+}
+
+// 处理来自 QQ 的 clip 命令，剪辑文件足够小时上传到 QQ 或 QQ 群，否则只回复文件路径
+func miraiClip(target message.Source, args []string) string {
+	uid, minutes, ok := parseClipArgs(args)
+	if !ok {
+		return handleErrMsg
+	}
+	c, err := clip(uid, minutes)
+	if err != nil {
+		return "剪辑失败：" + err.Error()
+	}
+	msg := fmt.Sprintf("剪辑%s的录播的最后%d分钟成功，文件保存在%s", c.Name, minutes, c.File)
+	if c.Size > clipUploadMaxSize {
+		return fmt.Sprintf("%s，文件大小超过%dMB，不上传到QQ", msg, clipUploadMaxSize/1024/1024)
+	}
+	f, err := os.Open(c.File)
+	if err != nil {
+		return fmt.Sprintf("%s，打开文件失败：%v", msg, err)
+	}
+	defer f.Close()
+	if miraiClient == nil {
+		return msg
+	}
+	lPrintf("上传剪辑的文件 %s 到 QQ", c.File)
+	if err := miraiClient.UploadFile(target, &client.LocalFile{
+		FileName:     filepath.Base(c.File),
+		Body:         f,
+		RemoteFolder: "/",
+	}); err != nil {
+		lPrintErrf("上传剪辑的文件 %s 到 QQ 失败：%v", c.File, err)
+		return fmt.Sprintf("%s，上传到QQ失败：%v", msg, err)
+	}
+	return msg + "，已上传到QQ"
+}
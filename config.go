@@ -4,6 +4,7 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
@@ -24,6 +25,9 @@ const (
 	configFile = "config.json" // 设置文件名字
 )
 
+// 默认的录播和弹幕的文件名模板
+const defaultFilenameTemplate = "{date} {time} {name} {title}"
+
 var (
 	liveFileLocation   string // 主播设置文件位置
 	configFileLocation string // 设置文件位置
@@ -31,16 +35,35 @@ var (
 
 // 主播的设置数据
 type streamer struct {
-	UID         int     `json:"uid"`         // 主播 uid
-	Name        string  `json:"name"`        // 主播名字
-	Notify      notify  `json:"notify"`      // 开播提醒相关
-	Record      bool    `json:"record"`      // 是否自动下载直播视频
-	Danmu       bool    `json:"danmu"`       // 是否自动下载直播弹幕
-	KeepOnline  bool    `json:"keepOnline"`  // 是否在该主播的直播间挂机，目前主要用于挂粉丝牌等级
-	Bitrate     int     `json:"bitrate"`     // 下载直播视频的最高码率
-	Directory   string  `json:"directory"`   // 直播视频和弹幕下载结束后会被移动到该文件夹，会覆盖 config.json 里的设置
-	SendQQ      []int64 `json:"sendQQ"`      // 给这些 QQ 号发送消息，会覆盖 config.json 里的设置
-	SendQQGroup []int64 `json:"sendQQGroup"` // 给这些 QQ 群发送消息，会覆盖 config.json 里的设置
+	UID              int              `json:"uid"`              // 主播 uid
+	Name             string           `json:"name"`             // 主播名字
+	Notify           notify           `json:"notify"`           // 开播提醒相关
+	Record           bool             `json:"record"`           // 是否自动下载直播视频
+	Danmu            bool             `json:"danmu"`            // 是否自动下载直播弹幕
+	KeepOnline       bool             `json:"keepOnline"`       // 是否在该主播的直播间挂机，目前主要用于挂粉丝牌等级
+	Bitrate          int              `json:"bitrate"`          // 下载直播视频的最高码率
+	Source           string           `json:"source"`           // 直播源类型，有 hls 和 flv 两种，为空时使用 config.json 里的设置
+	Quality          []string         `json:"quality"`          // 按顺序优先选择的直播源画质，可以是画质名字、画质类型或分辨率，都没有时按 Bitrate 选择
+	ExtraQualities   []string         `json:"extraQualities"`   // 同时下载的其他画质，每个画质单独保存为一个文件
+	Priority         int              `json:"priority"`         // 下载直播视频的优先级，越大越优先
+	AudioOnly        bool             `json:"audioOnly"`        // 是否只下载直播音频，会选择码率最低的直播源
+	AudioFormat      string           `json:"audioFormat"`      // 只下载音频时的音频格式，有 m4a 和 opus 两种，为空时为 m4a
+	Retention        *retention       `json:"retention"`        // 录播保留规则，为 null 时使用 config.json 里的设置
+	Schedule         []scheduleWindow `json:"schedule"`         // 录制时间段，只在这些时间段内自动下载直播视频和弹幕，为空时不限制
+	RecordRules      []recordRule     `json:"recordRules"`      // 按直播间标题和分类决定是否自动下载直播视频和弹幕的规则，为空时不限制
+	MaxDuration      int              `json:"maxDuration"`      // 每场直播最长下载多少分钟，为 0 时不限制
+	Directory        string           `json:"directory"`        // 直播视频和弹幕下载结束后会被移动到该文件夹，会覆盖 config.json 里的设置
+	FilenameTemplate string           `json:"filenameTemplate"` // 录播和弹幕的文件名模板，会覆盖 config.json 里的设置
+	SplitDuration    int              `json:"splitDuration"`    // 录播时长超过多少分钟时分段，为 0 时不按时长分段
+	SplitSize        int              `json:"splitSize"`        // 录播文件超过多少 MB 时分段，为 0 时不按大小分段
+	SplitOnTitle     bool             `json:"splitOnTitle"`     // 直播间标题改变时是否分段
+	MergeParts       bool             `json:"mergeParts"`       // 直播结束后是否合并因意外结束而重启下载产生的分段
+	Pipeline         []pipelineStep   `json:"pipeline"`         // 下载结束后的后期处理步骤，会覆盖 config.json 里的设置
+	FFmpegInputArgs  []string         `json:"ffmpegInputArgs"`  // 使用 FFmpeg 下载时添加在 -i 前面的参数
+	FFmpegOutputArgs []string         `json:"ffmpegOutputArgs"` // 使用 FFmpeg 下载时添加在输出文件前面的参数
+	Command          []string         `json:"command"`          // 下载直播视频的外部命令，第一个元素是程序，不为空时不使用 FFmpeg 和内置下载器
+	SendQQ           []int64          `json:"sendQQ"`           // 给这些 QQ 号发送消息，会覆盖 config.json 里的设置
+	SendQQGroup      []int64          `json:"sendQQGroup"`      // 给这些 QQ 群发送消息，会覆盖 config.json 里的设置
 }
 
 // 存放主播的设置数据
@@ -52,21 +75,44 @@ var streamers struct {
 
 // 设置数据
 type configData struct {
-	Source         string    `json:"source"`         // 直播源，有 hls 和 flv 两种
-	Output         string    `json:"output"`         // 直播下载视频格式的后缀名
-	WebPort        int       `json:"webPort"`        // web API 的本地端口
-	Directory      string    `json:"directory"`      // 直播视频和弹幕下载结束后会被移动到该文件夹，会被 live.json 里的设置覆盖
-	Acfun          acfunUser `json:"acfun"`          // AcFun 帐号相关
-	AutoKeepOnline bool      `json:"autoKeepOnline"` // 是否自动在有守护徽章的直播间挂机
-	Mirai          miraiData `json:"mirai"`          // Mirai 相关设置
+	Source           string              `json:"source"`           // 直播源，有 hls 和 flv 两种
+	Output           string              `json:"output"`           // 直播下载视频格式的后缀名
+	Recorder         string              `json:"recorder"`         // 下载直播视频的方式，有 auto、ffmpeg 和 native 三种
+	WebPort          int                 `json:"webPort"`          // web API 的本地端口
+	Directory        string              `json:"directory"`        // 直播视频和弹幕下载结束后会被移动到该文件夹，会被 live.json 里的设置覆盖
+	FilenameTemplate string              `json:"filenameTemplate"` // 录播和弹幕的文件名模板，/ 为文件夹分隔符，会被 live.json 里的设置覆盖
+	Pipeline         []pipelineStep      `json:"pipeline"`         // 下载结束后的后期处理步骤，会被 live.json 里的设置覆盖
+	Profiles         map[string][]string `json:"profiles"`         // 转码设置，值为 FFmpeg 的输出参数
+	JobWorkers       int                 `json:"jobWorkers"`       // 同时运行的后期处理任务的数量
+	BurnDanmu        burnDanmuConfig     `json:"burnDanmu"`        // 后期处理的 burndanmu 步骤相关
+	MinFreeSpace     int                 `json:"minFreeSpace"`     // 下载文件夹所在磁盘的最小剩余空间（MB），为 0 时不检查
+	StallTimeout     int                 `json:"stallTimeout"`     // 下载的直播视频超过多少秒没有增长时重启下载，为 0 时不检查
+	MaxRecordings    int                 `json:"maxRecordings"`    // 同时下载的直播视频的最大数量，为 0 时不限制
+	Preempt          bool                `json:"preempt"`          // 达到最大数量时，优先级更高的主播是否抢占优先级最低的直播视频的下载
+	Retention        retention           `json:"retention"`        // 录播保留规则，会被 live.json 里的设置覆盖
+	Discovery        []discoveryRule     `json:"discovery"`        // 发现规则，自动提醒和下载符合规则的没有订阅的主播的直播
+	Acfun            acfunUser           `json:"acfun"`            // AcFun 帐号相关
+	AutoKeepOnline   bool                `json:"autoKeepOnline"`   // 是否自动在有守护徽章的直播间挂机
+	Mirai            miraiData           `json:"mirai"`            // Mirai 相关设置
 }
 
 // 默认设置
 var config = configData{
-	Source:    "flv",
-	Output:    "mp4",
-	WebPort:   51880,
-	Directory: "",
+	Source:           "flv",
+	Output:           "mp4",
+	Recorder:         autoRecorder,
+	WebPort:          51880,
+	Directory:        "",
+	FilenameTemplate: defaultFilenameTemplate,
+	Pipeline:         []pipelineStep{},
+	Profiles:         map[string][]string{},
+	JobWorkers:       1,
+	BurnDanmu: burnDanmuConfig{
+		Workers: 1,
+		Args:    []string{"-c:v", "libx264", "-preset", "veryfast", "-crf", "23", "-c:a", "copy"},
+	},
+	MinFreeSpace: 0,
+	StallTimeout: 60,
 	Acfun: acfunUser{
 		Account:  "",
 		Password: "",
@@ -168,6 +214,28 @@ func loadLiveConfig() {
 			for _, s := range ss {
 				s.SendQQ = removeDup(s.SendQQ)
 				s.SendQQGroup = removeDup(s.SendQQGroup)
+				if s.Source != "" && s.Source != "hls" && s.Source != "flv" {
+					lPrintWarnf("%s里%s的 source 必须是 hls 或 flv，使用%s里的设置", liveFile, s.longID(), configFile)
+					s.Source = ""
+				}
+				schedule := s.Schedule[:0:0]
+				for _, w := range s.Schedule {
+					if w.valid() {
+						schedule = append(schedule, w)
+					} else {
+						lPrintWarnf("%s里%s的录制时间段 %s-%s 格式错误，忽略该时间段", liveFile, s.longID(), w.Start, w.End)
+					}
+				}
+				s.Schedule = schedule
+				rules := s.RecordRules[:0:0]
+				for _, r := range s.RecordRules {
+					if err := r.valid(); err == nil {
+						rules = append(rules, r)
+					} else {
+						lPrintWarnf("%s里%s的下载规则 %s 设置错误，忽略该规则：%v", liveFile, s.longID(), r.Pattern, err)
+					}
+				}
+				s.RecordRules = rules
 				news[s.UID] = s
 			}
 			streamers.crt = news
@@ -186,6 +254,15 @@ func loadConfig() {
 		if json.Valid(data) {
 			err = json.Unmarshal(data, &config)
 			checkErr(err)
+			discovery := config.Discovery[:0:0]
+			for _, r := range config.Discovery {
+				if err := r.valid(); err == nil {
+					discovery = append(discovery, r)
+				} else {
+					lPrintWarnf("%s里的%s设置错误，忽略该规则：%v", configFile, &r, err)
+				}
+			}
+			config.Discovery = discovery
 		} else {
 			lPrintErr("设置文件" + configFile + "的内容不符合 json 格式，请检查其内容")
 		}
@@ -337,63 +414,89 @@ func loadNewConfig() {
 	streamers.Unlock()
 }
 
-// 移动文件
-func (s *streamer) moveFile(oldFile string) {
-	if oldFile == "" {
-		return
+// 录播和弹幕文件在 directory 下的路径，保留文件名模板生成的子文件夹
+func destFile(oldFile, directory string) string {
+	if rel, err := filepath.Rel(*recordDir, oldFile); err == nil && !strings.HasPrefix(rel, "..") {
+		return filepath.Join(directory, rel)
 	}
-	directory := config.Directory
-	if s.Directory != "" {
-		directory = s.Directory
+	return filepath.Join(directory, filepath.Base(oldFile))
+}
+
+// 复制文件到 directory，返回新文件
+func copyFileTo(oldFile, directory string) (newFile string, e error) {
+	info, err := os.Stat(directory)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("%s 必须是存在的文件夹", directory)
 	}
 
-	if directory != "" {
-		info, err := os.Stat(directory)
-		checkErr(err)
-		if !info.IsDir() {
-			lPrintErrf("%s 或 %s 里的directory必须是存在的文件夹：%s", configFile, liveFile, directory)
-			return
+	newFile = destFile(oldFile, directory)
+	if err = os.MkdirAll(filepath.Dir(newFile), 0755); err != nil {
+		return "", err
+	}
+	inputFile, err := os.Open(oldFile)
+	if err != nil {
+		return "", err
+	}
+	defer inputFile.Close()
+	outputFile, err := os.Create(newFile)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err := outputFile.Close(); err != nil && e == nil {
+			e = err
 		}
+	}()
+	if _, err = io.Copy(outputFile, inputFile); err != nil {
+		return "", err
+	}
+	lPrintf("成功将文件 %s 复制到 %s", oldFile, newFile)
+	return newFile, nil
+}
 
-		_, err = os.Stat(oldFile)
-		if os.IsNotExist(err) {
-			lPrintErrf("文件 %s 不存在", oldFile)
-			return
-		}
-		checkErr(err)
+// 移动文件到 directory，返回新文件
+func moveFileTo(oldFile, directory string) (string, error) {
+	info, err := os.Stat(directory)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("%s 必须是存在的文件夹", directory)
+	}
+	if _, err = os.Stat(oldFile); err != nil {
+		return "", err
+	}
+
+	newFile := destFile(oldFile, directory)
+	if err = os.MkdirAll(filepath.Dir(newFile), 0755); err != nil {
+		return "", err
+	}
 
-		filename := filepath.Base(oldFile)
-		newFile := filepath.Join(directory, filename)
+	// https://github.com/cloudfoundry/bosh-utils/blob/master/fileutil/mover.go
+	err = os.Rename(oldFile, newFile)
+	if err != nil {
+		le, ok := err.(*os.LinkError)
+		if !ok {
+			return "", err
+		}
 
-		// https://github.com/cloudfoundry/bosh-utils/blob/master/fileutil/mover.go
-		err = os.Rename(oldFile, newFile)
-		if err != nil {
-			le, ok := err.(*os.LinkError)
-			if !ok {
-				lPrintErrf("将文件 %s 移动到 %s 失败：%v", oldFile, newFile, err)
-				return
+		if le.Err == syscall.EXDEV || (runtime.GOOS == "windows" && le.Err == syscall.Errno(0x11)) {
+			if _, err = copyFileTo(oldFile, directory); err != nil {
+				return "", err
 			}
-
-			if le.Err == syscall.EXDEV || (runtime.GOOS == "windows" && le.Err == syscall.Errno(0x11)) {
-				inputFile, err := os.Open(oldFile)
-				checkErr(err)
-				defer inputFile.Close()
-				outputFile, err := os.Create(newFile)
-				checkErr(err)
-				defer outputFile.Close()
-				_, err = io.Copy(outputFile, inputFile)
-				checkErr(err)
-				_ = inputFile.Close()
-				err = os.Remove(oldFile)
-				checkErr(err)
-			} else {
-				lPrintErrf("将文件 %s 移动到 %s 失败：%+v", oldFile, newFile, le)
-				return
+			if err = os.Remove(oldFile); err != nil {
+				return "", err
 			}
+		} else {
+			return "", le
 		}
-
-		lPrintf("成功将文件 %s 移动到 %s", oldFile, newFile)
 	}
+
+	lPrintf("成功将文件 %s 移动到 %s", oldFile, newFile)
+	return newFile, nil
 }
 
 // 设置 live.json 里类型为 bool 的值
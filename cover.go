@@ -0,0 +1,140 @@
+// 直播间封面和主播头像相关
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/valyala/fasthttp"
+)
+
+// 直播的封面和主播头像
+type liveImage struct {
+	liveID string // 直播 ID
+	cover  []byte // 直播间封面
+	avatar []byte // 主播头像
+}
+
+// 正在直播的主播的封面和头像，key 为主播 uid
+var liveImages struct {
+	sync.Mutex
+	images map[int]*liveImage
+}
+
+func init() {
+	liveImages.images = make(map[int]*liveImage)
+}
+
+// 下载图片
+func fetchImage(url string) (img []byte, e error) {
+	defer func() {
+		if err := recover(); err != nil {
+			e = fmt.Errorf("fetchImage() error: %v", err)
+		}
+	}()
+
+	if url == "" {
+		return nil, nil
+	}
+	client := &httpClient{
+		url:    url,
+		method: fasthttp.MethodGet,
+	}
+	resp, err := client.doRequest()
+	checkErr(err)
+	defer fasthttp.ReleaseResponse(resp)
+	if resp.StatusCode() != fasthttp.StatusOK {
+		return nil, fmt.Errorf("下载图片 %s 失败，响应码为 %d", url, resp.StatusCode())
+	}
+	return append([]byte(nil), getBody(resp)...), nil
+}
+
+// 获取主播现在的直播的封面和头像，没有缓存时下载，主播不在直播时返回 nil
+func getLiveImage(uid int) *liveImage {
+	// 不能同时锁住 liveImages 和 liveRooms，防止死锁
+	liveRooms.RLock()
+	room, ok := liveRooms.rooms[uid]
+	var liveID, coverURL, avatarURL string
+	if ok {
+		liveID, coverURL, avatarURL = room.liveID, room.cover, room.avatar
+	}
+	liveRooms.RUnlock()
+	if !ok {
+		isLive, room, err := tryFetchLiveInfo(uid)
+		if err != nil {
+			return nil
+		}
+		liveID, coverURL, avatarURL = room.liveID, room.cover, room.avatar
+		liveRoomPool.Put(room)
+		if !isLive {
+			return nil
+		}
+	}
+
+	liveImages.Lock()
+	img, ok := liveImages.images[uid]
+	liveImages.Unlock()
+	if ok && img.liveID == liveID {
+		return img
+	}
+	img = &liveImage{liveID: liveID}
+	var err error
+	if img.cover, err = fetchImage(coverURL); err != nil {
+		lPrintErrf("下载%s的直播间封面失败：%v", longID(uid), err)
+	}
+	if img.avatar, err = fetchImage(avatarURL); err != nil {
+		lPrintErrf("下载%s的头像失败：%v", longID(uid), err)
+	}
+	liveImages.Lock()
+	liveImages.images[uid] = img
+	liveImages.Unlock()
+	return img
+}
+
+// 删除已经下播的主播的封面和头像，需要锁住 liveRooms
+func pruneLiveImages(rooms map[int]*liveRoom) {
+	liveImages.Lock()
+	defer liveImages.Unlock()
+	for uid, img := range liveImages.images {
+		if room, ok := rooms[uid]; !ok || room.liveID != img.liveID {
+			delete(liveImages.images, uid)
+		}
+	}
+}
+
+// 图片的后缀名
+func imageExt(img []byte) string {
+	switch http.DetectContentType(img) {
+	case "image/png":
+		return ".png"
+	case "image/gif":
+		return ".gif"
+	case "image/webp":
+		return ".webp"
+	default:
+		return ".jpg"
+	}
+}
+
+// 将直播间封面和头像保存在录播旁边，文件名为 baseFile.cover 和 baseFile.avatar 加上后缀名，返回保存的文件名
+func (s *streamer) saveLiveImages(liveID, baseFile string) (cover, avatar string) {
+	img := getLiveImage(s.UID)
+	if img == nil || img.liveID != liveID {
+		return "", ""
+	}
+	save := func(data []byte, suffix string) string {
+		if len(data) == 0 {
+			return ""
+		}
+		file := baseFile + suffix + imageExt(data)
+		if err := os.WriteFile(file, data, 0644); err != nil {
+			lPrintErrf("保存文件 %s 失败：%v", file, err)
+			return ""
+		}
+		return filepath.Base(file)
+	}
+	return save(img.cover, ".cover"), save(img.avatar, ".avatar")
+}
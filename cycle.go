@@ -3,7 +3,6 @@ package main
 
 import (
 	"context"
-	"fmt"
 	"math/rand"
 	"time"
 )
@@ -65,6 +64,10 @@ func (s streamer) cycle(liveID string) {
 	lPrintln("开始监听" + s.longID() + "的直播状态")
 
 	var isLive bool
+	// 直播时是否因为不在录制时间段内或不符合下载规则而没有下载
+	var waiting bool
+	// 上次检查的直播间标题和分类
+	var title, category string
 	for {
 		select {
 		case msg := <-ch:
@@ -84,34 +87,41 @@ func (s streamer) cycle(liveID string) {
 				if liveID != newLiveID || modify {
 					liveID = newLiveID
 					modify = false
-					title := s.getTitle()
+					title, category = s.getTitle(), s.getCategory()
 					lPrintln(s.longID() + "正在直播：" + title)
 					lPrintln(s.Name + "的直播观看地址：" + s.getURL())
+					addHistory(historyEntry{Type: historyLiveOn, UID: s.UID, Name: s.Name, LiveID: liveID, Title: title})
 
 					if s.Notify.NotifyOn {
-						desktopNotify(s.Name + "正在直播：" + title)
-						s.sendMirai(fmt.Sprintf("%s正在直播：%s，观看地址：%s", s.Name, title, s.getURL()), true)
+						s.notifyLiveOn(title)
 					}
 
-					info, _ := getLiveInfo(liveID)
-
-					// 优先级：录播 > 弹幕/挂机
-					if s.Record && !info.isRecording {
-						go s.recordLive(s.Danmu || s.KeepOnline)
-					} else {
-						lPrintf("如果要临时下载%s的直播视频，可以运行 startrecord %d 或 startrecdan %d", s.Name, s.UID, s.UID)
-						// 不下载直播视频时下载弹幕
-						if (s.Danmu && !info.isDanmu) || (s.KeepOnline && !info.isKeepOnline) {
-							filename := getTime() + " " + s.Name + " " + title
-							go s.initDanmu(mainCtx, liveID, filename)
+					waiting = false
+					ok, reason := s.checkRecordRules(title, category)
+					if reason != "" {
+						lPrintln(s.longID() + "的" + reason)
+					}
+					switch {
+					case !ok:
+						waiting = true
+						lPrintln("不自动下载" + s.longID() + "的直播，直播间标题或分类符合下载规则后再开始下载")
+					case !s.inSchedule(time.Now()):
+						waiting = true
+						if s.Record || s.Danmu {
+							lPrintln("现在不在" + s.longID() + "的录制时间段内，进入录制时间段后再开始下载")
 						}
+					default:
+						s.startCapture(liveID, title)
 					}
+				} else {
+					s.checkCapture(liveID, &title, &category, &waiting)
 				}
 			} else {
 				// 应付 AcFun API 可能出现的 bug：主播没下播但 API 显示下播
 				if isLive && !s.isLiveOnByPage() {
 					isLive = false
 					lPrintln(s.longID() + "已经下播")
+					addHistory(historyEntry{Type: historyLiveOff, UID: s.UID, Name: s.Name, LiveID: liveID})
 					if s.Notify.NotifyOff {
 						msg := s.Name + "已经下播"
 						desktopNotify(msg)
@@ -125,6 +135,59 @@ func (s streamer) cycle(liveID string) {
 	}
 }
 
+// 直播过程中直播间标题或分类改变、进出录制时间段时按照下载规则和录制时间段开始或停止下载
+func (s *streamer) checkCapture(liveID string, title, category *string, waiting *bool) {
+	newTitle, newCategory := s.getTitle(), s.getCategory()
+	changed := newTitle != "" && (newTitle != *title || newCategory != *category)
+	if changed {
+		*title, *category = newTitle, newCategory
+	}
+	ok, reason := s.checkRecordRules(*title, *category)
+	if changed && reason != "" {
+		lPrintln(s.longID() + "的直播间标题或分类改变，" + reason)
+	}
+	inSchedule := s.inSchedule(time.Now())
+
+	switch {
+	case *waiting && ok && inSchedule:
+		*waiting = false
+		if s.Record || s.Danmu {
+			if changed {
+				lPrintln("符合下载规则，开始下载" + s.longID() + "的直播")
+			} else {
+				lPrintln("进入" + s.longID() + "的录制时间段")
+			}
+		}
+		s.startCapture(liveID, *title)
+	case *waiting:
+	case !inSchedule:
+		// 正在下载的由 cycleSchedule() 停止
+		*waiting = true
+	case changed && !ok:
+		*waiting = true
+		if info, ok := getLiveInfo(liveID); ok && (info.isRecording || info.isDanmu) {
+			s.stopCapture(info, reason)
+		}
+	}
+}
+
+// 按照设置开始下载直播视频和弹幕
+func (s *streamer) startCapture(liveID, title string) {
+	info, _ := getLiveInfo(liveID)
+
+	// 优先级：录播 > 弹幕/挂机
+	if s.Record && !info.isRecording {
+		go s.recordLive(s.Danmu || s.KeepOnline)
+	} else {
+		lPrintf("如果要临时下载%s的直播视频，可以运行 startrecord %d 或 startrecdan %d", s.Name, s.UID, s.UID)
+		// 不下载直播视频时下载弹幕
+		if (s.Danmu && !info.isDanmu) || (s.KeepOnline && !info.isKeepOnline) {
+			filename := s.getFilename(title, liveID, 1)
+			go s.recordDanmu(mainCtx, liveID, filename)
+		}
+	}
+}
+
 // 循环检测删除 lInfoMap.info 里没有下载视频和弹幕以及不在挂机的 key
 func cycleDelKey(ctx context.Context) {
 	for {
@@ -210,6 +273,8 @@ func cycleFetch(ctx context.Context) {
 					liveRoomPool.Put(room)
 				}
 				liveRooms.rooms = liveRooms.newRooms
+				discover(liveRooms.rooms)
+				pruneLiveImages(liveRooms.rooms)
 				liveRooms.Unlock()
 			}
 
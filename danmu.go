@@ -64,7 +64,6 @@ func (s streamer) getDanmu(ctx context.Context, info liveInfo) {
 	_ = ac.StartDanmu(ctx, false)
 	if s.Danmu {
 		ac.WriteASS(ctx, info.cfg, info.assFile, true)
-		defer s.moveFile(info.assFile)
 	} else if s.KeepOnline {
 		for {
 			if danmu := ac.GetDanmu(); danmu == nil {
@@ -140,8 +139,34 @@ func (s streamer) quitDanmu(liveID string) {
 	}
 }
 
-// 初始化弹幕下载
-func (s streamer) initDanmu(ctx context.Context, liveID, filename string) {
+// 单独下载直播弹幕，下载结束后进行后期处理
+func (s streamer) recordDanmu(ctx context.Context, liveID, filename string) {
+	title := s.getTitle()
+	start := time.Now().UnixMilli()
+	if assFile := s.initDanmu(ctx, liveID, uniqueFilename(filename, "")); assFile != "" {
+		sc := &sidecar{
+			UID:       s.UID,
+			Name:      s.Name,
+			LiveID:    liveID,
+			Title:     title,
+			Source:    s.source(),
+			Part:      1,
+			Reason:    reasonStart,
+			Start:     start,
+			End:       time.Now().UnixMilli(),
+			EndReason: reasonInterrupted,
+			Danmu:     filepath.Base(assFile),
+		}
+		if info, ok := getLiveInfo(liveID); ok {
+			sc.LiveStartTime = info.LiveStartTime
+		}
+		writeSidecar(sidecarFile(assFile), sc)
+		s.postRecord(liveID, "", assFile)
+	}
+}
+
+// 初始化弹幕下载，baseFile 为转换后的不包括后缀名的文件名，返回下载的 ass 文件，没有下载弹幕时返回空字符串
+func (s streamer) initDanmu(ctx context.Context, liveID, baseFile string) string {
 	dctx, dcancel := context.WithCancel(ctx)
 	defer dcancel()
 	info, ok := getLiveInfo(liveID)
@@ -149,18 +174,18 @@ func (s streamer) initDanmu(ctx context.Context, liveID, filename string) {
 		if info.isDanmu && !info.isKeepOnline {
 			if s.Danmu && !s.KeepOnline {
 				lPrintWarnf("已经在下载%s的直播弹幕，如要重启下载，请先运行 stopdanmu %d", s.longID(), s.UID)
-				return
+				return ""
 			}
 			s.Danmu = false
 		} else if !info.isDanmu && info.isKeepOnline {
 			if !s.Danmu && s.KeepOnline {
 				lPrintWarn("已经在" + s.longID() + "的直播间挂机")
-				return
+				return ""
 			}
 			s.KeepOnline = false
 		} else if info.isDanmu && info.isKeepOnline {
 			lPrintWarnf("已经在下载%s的直播弹幕和在其直播间挂机，如要重启下载，请先运行 stopdanmu %d", s.longID(), s.UID)
-			return
+			return ""
 		}
 	} else {
 		// 获取直播源和对应的弹幕设置
@@ -174,30 +199,36 @@ func (s streamer) initDanmu(ctx context.Context, liveID, filename string) {
 				desktopNotify("无法获取" + s.Name + "的直播源，退出下载直播弹幕")
 				s.sendMirai(fmt.Sprintf(msg, s.Name, s.UID), false)
 			}
-			return
+			return ""
 		}
 	}
 
-	if s.Danmu {
-		info.isDanmu = true
-		info.danmuCancel = dcancel
-	}
-	if s.KeepOnline {
-		info.isKeepOnline = true
-		info.onlineCancel = dcancel
+	if baseFile == "" {
+		return ""
 	}
-
-	assFile := transFilename(filename)
-	if assFile == "" {
-		return
-	}
-	info.assFile = assFile + ".ass"
-	info.cfg.Title = filepath.Base(assFile)
+	info.assFile = baseFile + ".ass"
+	info.cfg.Title = filepath.Base(baseFile)
 	info.cfg.StartTime = time.Now().UnixNano()
-	setLiveInfo(info)
+	// 下载直播视频时会同时修改 lInfoMap，只修改弹幕相关的数据
+	updateLiveInfo(info, func(i *liveInfo) {
+		if s.Danmu {
+			i.isDanmu = true
+			i.danmuCancel = dcancel
+		}
+		if s.KeepOnline {
+			i.isKeepOnline = true
+			i.onlineCancel = dcancel
+		}
+		i.assFile = info.assFile
+		i.cfg = info.cfg
+	})
 	defer s.quitDanmu(info.LiveID)
 
 	s.getDanmu(dctx, info)
+	if s.Danmu {
+		return info.assFile
+	}
+	return ""
 }
 
 // 临时下载指定主播的直播弹幕
@@ -224,15 +255,15 @@ func startDanmu(uid int) bool {
 		return false
 	}
 
-	filename := getTime() + " " + s.Name + " " + s.getTitle()
+	filename := s.getFilename(s.getTitle(), liveID, 1)
 
 	// 查看程序是否处于监听状态
 	if *isListen {
 		// goroutine 是为了快速返回
-		go s.initDanmu(mainCtx, liveID, filename)
+		go s.recordDanmu(mainCtx, liveID, filename)
 	} else {
 		// 程序只在单独下载一个直播弹幕，不用 goroutine，防止程序提前结束运行
-		s.initDanmu(mainCtx, liveID, filename)
+		s.recordDanmu(mainCtx, liveID, filename)
 	}
 	return true
 }
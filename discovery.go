@@ -0,0 +1,115 @@
+// 按规则自动提醒和下载没有订阅的主播的直播相关
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+)
+
+// 发现规则，设置的正则表达式都要匹配才符合规则
+type discoveryRule struct {
+	Name     string `json:"name"`     // 匹配主播名字的正则表达式，为空时不限制
+	Title    string `json:"title"`    // 匹配直播间标题的正则表达式，为空时不限制
+	Category string `json:"category"` // 匹配直播分类的正则表达式，格式为 主分类/次分类，为空时不限制
+	Notify   bool   `json:"notify"`   // 是否发送开播提醒
+	Record   bool   `json:"record"`   // 是否下载直播视频
+	Danmu    bool   `json:"danmu"`    // 是否下载直播弹幕
+}
+
+// 已经按发现规则处理过的直播，key 为 liveID
+var discovered struct {
+	sync.Mutex
+	liveIDs map[string]bool
+}
+
+func init() {
+	discovered.liveIDs = make(map[string]bool)
+}
+
+// 检查发现规则的格式
+func (r *discoveryRule) valid() error {
+	if r.Name == "" && r.Title == "" && r.Category == "" {
+		return errors.New("name、title 和 category 不能都为空")
+	}
+	for _, pattern := range []string{r.Name, r.Title, r.Category} {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 规则的描述
+func (r *discoveryRule) String() string {
+	return fmt.Sprintf("发现规则（名字：%s，标题：%s，分类：%s）", r.Name, r.Title, r.Category)
+}
+
+// 是否符合规则
+func (r *discoveryRule) match(room *liveRoom) bool {
+	return matchPattern(r.Name, room.name) && matchPattern(r.Title, room.title) && matchPattern(r.Category, room.category)
+}
+
+// 按照发现规则处理直播间列表里没有订阅的主播，每场直播只处理一次，需要锁住 liveRooms
+func discover(rooms map[int]*liveRoom) {
+	if len(config.Discovery) == 0 {
+		return
+	}
+
+	discovered.Lock()
+	defer discovered.Unlock()
+	live := make(map[string]bool, len(rooms))
+	for uid, room := range rooms {
+		live[room.liveID] = true
+		if discovered.liveIDs[room.liveID] {
+			continue
+		}
+		// 订阅的主播按照自己的设置处理
+		if _, ok := getStreamer(uid); ok {
+			continue
+		}
+		for i := range config.Discovery {
+			r := &config.Discovery[i]
+			if !r.match(room) {
+				continue
+			}
+			discovered.liveIDs[room.liveID] = true
+			s := streamer{UID: uid, Name: room.name}
+			s.Notify.NotifyOn = r.Notify
+			s.Notify.NotifyRecord = r.Record
+			s.Record = r.Record
+			s.Danmu = r.Danmu
+			go s.discovered(room.liveID, room.title, r.String())
+			break
+		}
+	}
+	// 删除已经下播的直播
+	for liveID := range discovered.liveIDs {
+		if !live[liveID] {
+			delete(discovered.liveIDs, liveID)
+		}
+	}
+}
+
+// 处理符合发现规则的直播，s 是临时的主播设置，不会保存到 live.json
+func (s streamer) discovered(liveID, title, rule string) {
+	defer func() {
+		if err := recover(); err != nil {
+			lPrintErr("Recovering from panic in discovered(), the error is:", err)
+			lPrintErrf("处理符合发现规则的%s的直播时发生错误", s.longID())
+		}
+	}()
+
+	lPrintf("%s正在直播：%s，符合%s", s.longID(), title, rule)
+	if s.Notify.NotifyOn {
+		s.notifyLiveOn(title)
+	}
+
+	info, _ := getLiveInfo(liveID)
+	if s.Record && !info.isRecording {
+		s.recordLive(s.Danmu)
+	} else if s.Danmu && !info.isDanmu {
+		s.recordDanmu(mainCtx, liveID, s.getFilename(title, liveID, 1))
+	}
+}
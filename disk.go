@@ -0,0 +1,97 @@
+// 磁盘空间相关
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// 检查磁盘空间的时间间隔
+const diskCheckInterval = 30 * time.Second
+
+// 下载文件夹所在磁盘的剩余空间是否少于 config.MinFreeSpace，返回剩余空间（MB）
+func isLowDiskSpace() (free uint64, low bool) {
+	if config.MinFreeSpace <= 0 {
+		return 0, false
+	}
+	bytes, err := diskFree(*recordDir)
+	if err != nil {
+		lPrintErrf("获取文件夹 %s 所在磁盘的剩余空间失败：%v", *recordDir, err)
+		return 0, false
+	}
+	free = bytes / 1024 / 1024
+	return free, free < uint64(config.MinFreeSpace)
+}
+
+// 通知磁盘空间不足
+func (s *streamer) notifyLowDiskSpace(free uint64) {
+	msg := fmt.Sprintf("磁盘剩余空间只有%dMB，少于%dMB，停止下载%s的直播视频", free, config.MinFreeSpace, s.Name)
+	lPrintWarn(msg)
+	desktopNotify(msg)
+	s.sendMirai(msg, false)
+}
+
+// 主播的优先级，临时下载的主播为 0
+func priority(uid int) int {
+	if s, ok := getStreamer(uid); ok {
+		return s.Priority
+	}
+	return 0
+}
+
+// 停止下载直播视频的所有画质，c 为 stopRecord 时不再重启下载，为 danmuOnly 时改为只下载弹幕
+func stopLiveRecord(info liveInfo, c control) {
+	for _, rec := range info.recordings {
+		rec.recordCh <- c
+		rec.stop()
+	}
+}
+
+// 循环检查磁盘空间，不足时停止下载优先级最低的直播视频
+func cycleDiskSpace(ctx context.Context) {
+	defer func() {
+		if err := recover(); err != nil {
+			lPrintErr("Recovering from panic in cycleDiskSpace(), the error is:", err)
+			lPrintErr("检查磁盘空间时发生错误，停止检查磁盘空间")
+		}
+	}()
+
+	ticker := time.NewTicker(diskCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			free, low := isLowDiskSpace()
+			if !low {
+				continue
+			}
+			var recordings []liveInfo
+			lInfoMap.RLock()
+			for _, info := range lInfoMap.info {
+				// 跳过已经在停止的直播视频，防止在等待停止的时间里重复停止和通知
+				if info.isRecording && !info.isStopping() {
+					recordings = append(recordings, info)
+				}
+			}
+			lInfoMap.RUnlock()
+			var lowest *liveInfo
+			for i := range recordings {
+				if lowest == nil || priority(recordings[i].uid) < priority(lowest.uid) {
+					lowest = &recordings[i]
+				}
+			}
+			// 每次只停止一个，下次检查时空间依然不足再停止下一个
+			if lowest != nil {
+				s, ok := getStreamer(lowest.uid)
+				if !ok {
+					s = streamer{UID: lowest.uid, Name: getName(lowest.uid)}
+				}
+				s.notifyLowDiskSpace(free)
+				stopLiveRecord(*lowest, danmuOnly)
+			}
+		}
+	}
+}
@@ -3,8 +3,13 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"io"
+	"net"
 	"os"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -20,6 +25,9 @@ import (
 //const acLiveChannel = "https://api-plus.app.acfun.cn/rest/app/live/channel"
 //const acUserInfo2 = "https://api-new.app.acfun.cn/rest/app/user/userInfo?userId=%d"
 
+// 默认的 User-Agent
+const userAgent = "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/128.0.0.0 Safari/537.36"
+
 type httpClient struct {
 	client      *fasthttp.Client
 	url         string
@@ -37,6 +45,47 @@ var defaultClient = &fasthttp.Client{
 	WriteTimeout:        10 * time.Second,
 }
 
+// 下载直播源用的 client，连接超过 20 秒没有数据时断开
+var streamClient = &fasthttp.Client{
+	MaxIdleConnDuration: 90 * time.Second,
+	WriteTimeout:        10 * time.Second,
+	StreamResponseBody:  true,
+	Dial: func(addr string) (net.Conn, error) {
+		conn, err := fasthttp.DialTimeout(addr, 10*time.Second)
+		if err != nil {
+			return nil, err
+		}
+		return &timeoutConn{Conn: conn, timeout: 20 * time.Second}, nil
+	},
+}
+
+// 每次读取前都会重设超时的连接
+type timeoutConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+// 实现 io.Reader 接口
+func (c *timeoutConn) Read(b []byte) (int, error) {
+	if err := c.Conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(b)
+}
+
+// 直播源的响应 body
+type streamBody struct {
+	io.Reader
+	resp *fasthttp.Response
+}
+
+// 实现 io.Closer 接口
+func (b *streamBody) Close() error {
+	err := b.resp.CloseBodyStream()
+	fasthttp.ReleaseResponse(b.resp)
+	return err
+}
+
 var (
 	fetchRoomPool      fastjson.ParserPool
 	fetchLiveInfoPool  fastjson.ParserPool
@@ -46,9 +95,12 @@ var (
 
 // 直播间的数据结构
 type liveRoom struct {
-	name   string // 主播名字
-	title  string // 直播间标题
-	liveID string // 直播 ID
+	name     string // 主播名字
+	title    string // 直播间标题
+	category string // 直播分类，格式为 主分类/次分类
+	cover    string // 直播间封面的链接
+	avatar   string // 主播头像的链接
+	liveID   string // 直播 ID
 }
 
 // 守护徽章信息
@@ -131,8 +183,6 @@ func (c *httpClient) doRequest() (resp *fasthttp.Response, e error) {
 		}
 	}
 
-	const userAgent = "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/128.0.0.0 Safari/537.36"
-
 	if c.userAgent != "" {
 		req.Header.SetUserAgent(c.userAgent)
 	} else {
@@ -157,6 +207,56 @@ func (c *httpClient) doRequest() (resp *fasthttp.Response, e error) {
 	return resp, nil
 }
 
+// 以流的形式获取直播源，会自动跳转，调用后需要关闭返回的 body
+func fetchStream(ctx context.Context, url string) (body io.ReadCloser, e error) {
+	defer func() {
+		if err := recover(); err != nil {
+			e = fmt.Errorf("fetchStream() error: %v", err)
+		}
+	}()
+
+	for redirect := 0; redirect < 5; redirect++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		req := fasthttp.AcquireRequest()
+		req.SetRequestURI(url)
+		req.Header.SetMethod(fasthttp.MethodGet)
+		req.Header.SetUserAgent(userAgent)
+		resp := fasthttp.AcquireResponse()
+		err := streamClient.Do(req, resp)
+		fasthttp.ReleaseRequest(req)
+		if err != nil {
+			fasthttp.ReleaseResponse(resp)
+			return nil, err
+		}
+
+		switch code := resp.StatusCode(); {
+		case code == fasthttp.StatusOK:
+			return &streamBody{Reader: resp.BodyStream(), resp: resp}, nil
+		case fasthttp.StatusCodeIsRedirect(code):
+			location := string(resp.Header.Peek(fasthttp.HeaderLocation))
+			_ = resp.CloseBodyStream()
+			fasthttp.ReleaseResponse(resp)
+			if location == "" {
+				return nil, fmt.Errorf("请求 %s 时跳转的地址为空", url)
+			}
+			u := fasthttp.AcquireURI()
+			err = u.Parse(nil, []byte(url))
+			checkErr(err)
+			u.Update(location)
+			url = u.String()
+			fasthttp.ReleaseURI(u)
+		default:
+			_ = resp.CloseBodyStream()
+			fasthttp.ReleaseResponse(resp)
+			return nil, fmt.Errorf("请求 %s 时响应的状态码为 %d", url, code)
+		}
+	}
+
+	return nil, fmt.Errorf("请求 %s 时跳转次数过多", url)
+}
+
 // 获取响应 body
 func getBody(resp *fasthttp.Response) []byte {
 	if string(resp.Header.Peek("content-encoding")) == "gzip" || string(resp.Header.Peek("Content-Encoding")) == "gzip" {
@@ -228,6 +328,9 @@ func fetchLiveRoom(count int) (rooms map[int]*liveRoom, all bool, e error) {
 		room := liveRoomPool.Get().(*liveRoom)
 		room.name = string(live.GetStringBytes("user", "name"))
 		room.title = string(live.GetStringBytes("title"))
+		room.category = liveCategory(live)
+		room.cover = string(live.GetStringBytes("coverUrls", "0"))
+		room.avatar = string(live.GetStringBytes("user", "headUrl"))
 		room.liveID = string(live.GetStringBytes("liveId"))
 		rooms[uid] = room
 	}
@@ -274,6 +377,35 @@ func getTitle(uid int) string {
 	return ""
 }
 
+// 根据 uid 获取主播直播间的分类
+func getCategory(uid int) string {
+	liveRooms.RLock()
+	room, ok := liveRooms.rooms[uid]
+	if ok {
+		category := room.category
+		liveRooms.RUnlock()
+		return category
+	}
+	liveRooms.RUnlock()
+
+	if isLive, room, err := tryFetchLiveInfo(uid); err == nil {
+		defer liveRoomPool.Put(room)
+		if isLive {
+			return room.category
+		}
+	}
+	return ""
+}
+
+// 从直播信息里获取直播分类
+func liveCategory(v *fastjson.Value) string {
+	category := string(v.GetStringBytes("type", "categoryName"))
+	if sub := string(v.GetStringBytes("type", "name")); sub != "" {
+		category += "/" + sub
+	}
+	return category
+}
+
 // 根据 uid 获取 liveID，结果准确，可能需要检查返回是否为空
 func getLiveID(uid int) string {
 	if isLive, room, err := tryFetchLiveInfo(uid); err == nil {
@@ -306,6 +438,11 @@ func (s *streamer) getTitle() string {
 	return getTitle(s.UID)
 }
 
+// 获取主播直播间的分类
+func (s *streamer) getCategory() string {
+	return getCategory(s.UID)
+}
+
 // 获取 liveID，由于 AcFun 的 bug，结果不一定准确，可能需要检查返回是否为空
 func (s *streamer) getLiveID() string {
 	liveRooms.RLock()
@@ -359,14 +496,19 @@ func fetchLiveInfo(uid int) (isLive bool, room *liveRoom, e error) {
 	if v.Exists("liveId") {
 		isLive = true
 		room.title = string(v.GetStringBytes("title"))
+		room.category = liveCategory(v)
+		room.cover = string(v.GetStringBytes("coverUrls", "0"))
 		room.liveID = string(v.GetStringBytes("liveId"))
 	} else {
 		isLive = false
 		room.title = ""
+		room.category = ""
+		room.cover = ""
 		room.liveID = ""
 	}
 
 	room.name = string(v.GetStringBytes("user", "name"))
+	room.avatar = string(v.GetStringBytes("user", "headUrl"))
 
 	return isLive, room, nil
 }
@@ -509,8 +651,84 @@ func fetchAcLogo() {
 	checkErr(err)
 }
 
-// 获取 AcFun 的直播源信息，分为 hls 和 flv 两种
-func (s *streamer) getStreamInfo() (info streamInfo, e error) {
+// 直播源画质类型对应的分辨率
+var qualityResolutions = map[string]int{
+	"SMOOTH":   540,
+	"STANDARD": 540,
+	"HIGH":     720,
+	"SUPER":    720,
+	"BLUE_RAY": 1080,
+}
+
+// 直播源是否符合画质设置，q 可以是画质名字（如 超清、蓝光 4M）、画质类型（如 SUPER）或分辨率（如 720p）
+func matchQuality(stream acfundanmu.StreamURL, q string) bool {
+	q = strings.TrimSpace(q)
+	if strings.EqualFold(stream.QualityName, q) || strings.EqualFold(stream.QualityType, q) {
+		return true
+	}
+	if res, err := strconv.Atoi(strings.TrimSuffix(strings.ToLower(q), "p")); err == nil {
+		return qualityResolutions[stream.QualityType] == res
+	}
+	return false
+}
+
+// 按照 s.AudioOnly 和 s.Bitrate 选择直播源，返回在 list 里的序号
+func (s *streamer) defaultStream(list []acfundanmu.StreamURL) int {
+	index := 0
+	if s.AudioOnly {
+		// 只下载音频时选择码率最低的直播源
+		for i, stream := range list {
+			if stream.Bitrate < list[index].Bitrate {
+				index = i
+			}
+		}
+	} else if s.Bitrate == 0 {
+		// s.Bitrate 为 0 时选择码率最高的直播源
+		index = len(list) - 1
+	} else {
+		// 选择 s.Bitrate 下码率最高的直播源
+		for i, stream := range list {
+			if s.Bitrate >= stream.Bitrate {
+				index = i
+			} else {
+				break
+			}
+		}
+	}
+	return index
+}
+
+// 按优先顺序返回直播源的序号：先是 s.Quality 里的画质，然后是按码率选择的直播源，最后是其他直播源（码率高的在前）
+func (s *streamer) streamCandidates(list []acfundanmu.StreamURL) []int {
+	var candidates []int
+	added := make(map[int]bool)
+	add := func(i int) {
+		if !added[i] {
+			added[i] = true
+			candidates = append(candidates, i)
+		}
+	}
+	for _, q := range s.Quality {
+		// 有多个直播源符合时选择码率最高的
+		index := -1
+		for i, stream := range list {
+			if matchQuality(stream, q) && !added[i] && (index < 0 || stream.Bitrate > list[index].Bitrate) {
+				index = i
+			}
+		}
+		if index >= 0 {
+			add(index)
+		}
+	}
+	add(s.defaultStream(list))
+	for i := len(list) - 1; i >= 0; i-- {
+		add(i)
+	}
+	return candidates
+}
+
+// 获取 AcFun 的直播源信息，分为 hls 和 flv 两种，会跳过 skip 里的画质，所有画质都被跳过时不跳过
+func (s *streamer) getStreamInfo(skip ...string) (info streamInfo, e error) {
 	defer func() {
 		if err := recover(); err != nil {
 			e = fmt.Errorf("getStreamURL() error: %v", err)
@@ -527,31 +745,30 @@ func (s *streamer) getStreamInfo() (info streamInfo, e error) {
 	sInfo := ac.GetStreamInfo()
 	info.StreamInfo = *sInfo
 
-	index := 0
-	if s.Bitrate == 0 {
-		// s.Bitrate 为 0 时选择码率最高的直播源
-		index = len(sInfo.StreamList) - 1
-	} else {
-		// 选择 s.Bitrate 下码率最高的直播源
-		for i, stream := range sInfo.StreamList {
-			if s.Bitrate >= stream.Bitrate {
-				index = i
-			} else {
-				break
-			}
+	candidates := s.streamCandidates(sInfo.StreamList)
+	index := candidates[0]
+	for _, i := range candidates {
+		if !slices.Contains(skip, sInfo.StreamList[i].QualityName) {
+			index = i
+			break
 		}
 	}
 
 	info.flvURL = sInfo.StreamList[index].URL
+	info.quality = sInfo.StreamList[index].QualityName
+	info.bitrate = sInfo.StreamList[index].Bitrate
 
 	bitrate := sInfo.StreamList[index].Bitrate
+	res, ok := qualityResolutions[sInfo.StreamList[index].QualityType]
 	switch {
+	case bitrate == 0:
+		info.cfg = subConfigs[0]
+	case ok:
+		info.cfg = subConfigs[res]
 	case bitrate >= 4000:
 		info.cfg = subConfigs[1080]
 	case len(sInfo.StreamList) >= 2 && bitrate >= 2000:
 		info.cfg = subConfigs[720]
-	case bitrate == 0:
-		info.cfg = subConfigs[0]
 	default:
 		info.cfg = subConfigs[540]
 	}
@@ -563,8 +780,8 @@ func (s *streamer) getStreamInfo() (info streamInfo, e error) {
 	return info, nil
 }
 
-// 根据 config.Source 获取直播信息
-func (s *streamer) getLiveInfo() (info liveInfo, e error) {
+// 根据主播的直播源类型获取直播信息，skip 为需要跳过的画质
+func (s *streamer) getLiveInfo(skip ...string) (info liveInfo, e error) {
 	defer func() {
 		if err := recover(); err != nil {
 			e = fmt.Errorf("getLiveInfo() error: %v", err)
@@ -574,10 +791,10 @@ func (s *streamer) getLiveInfo() (info liveInfo, e error) {
 	info.uid = s.UID
 
 	var err error
-	info.streamInfo, err = s.getStreamInfo()
+	info.streamInfo, err = s.getStreamInfo(skip...)
 	checkErr(err)
 
-	switch config.Source {
+	switch s.source() {
 	case "hls":
 		info.streamURL = info.hlsURL
 	case "flv":
@@ -0,0 +1,300 @@
+// flv 相关
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// flv tag 的类型
+const (
+	flvTagAudio  = 8
+	flvTagVideo  = 9
+	flvTagScript = 18
+)
+
+const (
+	flvHeaderSize    = 9        // flv header 的长度
+	flvTagHeaderSize = 11       // flv tag header 的长度
+	flvMaxDataSize   = 16 << 20 // flv tag data 的最大长度
+	flvMaxJump       = 5000     // 相邻 tag 的时间戳相差超过这个值（毫秒）时认为时间戳出错
+	flvDefaultDelta  = 40       // 修复时间戳时默认使用的时间间隔（毫秒）
+)
+
+var errFLVHeader = errors.New("不是有效的 flv 文件头")
+
+// flv tag
+type flvTag struct {
+	tagType   uint8  // tag 类型
+	timestamp uint32 // 时间戳，单位为毫秒
+	data      []byte // tag data
+}
+
+// 读取并校验 flv tag
+type flvReader struct {
+	r *bufio.Reader
+}
+
+// 新建 flvReader
+func newFLVReader(r io.Reader) *flvReader {
+	return &flvReader{r: bufio.NewReaderSize(r, 64*1024)}
+}
+
+// 读取 flv header，返回 header 里的 flags
+func (fr *flvReader) readHeader() (flags byte, err error) {
+	header := make([]byte, flvHeaderSize)
+	if _, err = io.ReadFull(fr.r, header); err != nil {
+		return 0, err
+	}
+	if !bytes.Equal(header[:3], []byte("FLV")) {
+		return 0, errFLVHeader
+	}
+	offset := binary.BigEndian.Uint32(header[5:9])
+	if offset < flvHeaderSize {
+		return 0, errFLVHeader
+	}
+	// 跳过 header 多余的部分和 PreviousTagSize0
+	if _, err = fr.r.Discard(int(offset) - flvHeaderSize + 4); err != nil {
+		return 0, err
+	}
+	return header[4], nil
+}
+
+// 读取一个 flv tag，tag 不符合规范时返回错误
+func (fr *flvReader) readTag() (*flvTag, error) {
+	header := make([]byte, flvTagHeaderSize)
+	if _, err := io.ReadFull(fr.r, header); err != nil {
+		return nil, err
+	}
+	tag := &flvTag{tagType: header[0] & 0x1f}
+	if header[0]&0x20 != 0 {
+		return nil, fmt.Errorf("不支持加密的 flv tag")
+	}
+	switch tag.tagType {
+	case flvTagAudio, flvTagVideo, flvTagScript:
+	default:
+		return nil, fmt.Errorf("无效的 flv tag 类型：%d", tag.tagType)
+	}
+	size := uint32(header[1])<<16 | uint32(header[2])<<8 | uint32(header[3])
+	if size == 0 || size > flvMaxDataSize {
+		return nil, fmt.Errorf("无效的 flv tag 长度：%d", size)
+	}
+	tag.timestamp = uint32(header[7])<<24 | uint32(header[4])<<16 | uint32(header[5])<<8 | uint32(header[6])
+	tag.data = make([]byte, size)
+	if _, err := io.ReadFull(fr.r, tag.data); err != nil {
+		return nil, err
+	}
+	prev := make([]byte, 4)
+	if _, err := io.ReadFull(fr.r, prev); err != nil {
+		return nil, err
+	}
+	if prevSize := binary.BigEndian.Uint32(prev); prevSize != size+flvTagHeaderSize {
+		return nil, fmt.Errorf("flv tag 的 PreviousTagSize 不正确：%d，应为 %d", prevSize, size+flvTagHeaderSize)
+	}
+	return tag, nil
+}
+
+// 修复 flv 的时间戳，使其从 0 开始且不会大幅跳跃或倒退
+type flvTimestamp struct {
+	init   bool
+	offset int64           // 原时间戳需要加上的偏移
+	last   int64           // 最后一个 tag 修复后的时间戳
+	delta  int64           // 最近的正常时间间隔
+	types  map[uint8]int64 // 各类型 tag 最后的时间戳
+}
+
+// 返回修复后的时间戳
+func (t *flvTimestamp) fix(tag *flvTag) uint32 {
+	ts := int64(tag.timestamp)
+	if !t.init {
+		t.init = true
+		t.offset = -ts
+		t.delta = flvDefaultDelta
+		t.types = make(map[uint8]int64)
+	}
+	n := ts + t.offset
+	if d := n - t.last; d > flvMaxJump || d < -flvMaxJump {
+		// 时间戳跳跃，接在上一个 tag 后面
+		t.offset = t.last + t.delta - ts
+		n = t.last + t.delta
+	} else if d > 0 && d < 1000 {
+		t.delta = d
+	}
+	// 同类型 tag 的时间戳不能倒退
+	if last, ok := t.types[tag.tagType]; ok && n < last {
+		n = last
+	}
+	if n < 0 {
+		n = 0
+	}
+	t.types[tag.tagType] = n
+	if n > t.last {
+		t.last = n
+	}
+	return uint32(n)
+}
+
+// 写入 flv 文件
+type flvWriter struct {
+	file      *os.File
+	w         *bufio.Writer
+	headerBuf [15]byte // tag header 和 PreviousTagSize 的缓存
+}
+
+// 创建 flv 文件并写入 flv header
+func createFLV(filename string, flags byte) (*flvWriter, error) {
+	f, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	fw := &flvWriter{file: f, w: bufio.NewWriterSize(f, 256*1024)}
+	header := []byte{'F', 'L', 'V', 1, flags, 0, 0, 0, flvHeaderSize, 0, 0, 0, 0}
+	if _, err = fw.w.Write(header); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return fw, nil
+}
+
+// 写入 flv tag
+func (fw *flvWriter) writeTag(tag *flvTag) error {
+	size := uint32(len(tag.data))
+	h := fw.headerBuf[:flvTagHeaderSize]
+	h[0] = tag.tagType
+	h[1], h[2], h[3] = byte(size>>16), byte(size>>8), byte(size)
+	h[4], h[5], h[6], h[7] = byte(tag.timestamp>>16), byte(tag.timestamp>>8), byte(tag.timestamp), byte(tag.timestamp>>24)
+	h[8], h[9], h[10] = 0, 0, 0
+	if _, err := fw.w.Write(h); err != nil {
+		return err
+	}
+	if _, err := fw.w.Write(tag.data); err != nil {
+		return err
+	}
+	prev := fw.headerBuf[flvTagHeaderSize:]
+	binary.BigEndian.PutUint32(prev, size+flvTagHeaderSize)
+	if _, err := fw.w.Write(prev); err != nil {
+		return err
+	}
+	return nil
+}
+
+// 关闭 flv 文件
+func (fw *flvWriter) close() error {
+	err := fw.w.Flush()
+	if e := fw.file.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+// 模仿 FFmpeg 的 stdin，写入 q 时通知内置下载器停止下载
+type quitWriter struct {
+	once sync.Once
+	quit chan struct{}
+}
+
+// 新建 quitWriter
+func newQuitWriter() *quitWriter {
+	return &quitWriter{quit: make(chan struct{})}
+}
+
+// 实现 io.Writer 接口
+func (w *quitWriter) Write(p []byte) (int, error) {
+	if bytes.IndexByte(p, 'q') >= 0 {
+		w.once.Do(func() { close(w.quit) })
+	}
+	return len(p), nil
+}
+
+// 实现 io.Closer 接口
+func (w *quitWriter) Close() error {
+	return nil
+}
+
+// 使用内置的 flv 下载器下载直播视频，quit 被关闭时正常结束下载
+func recordFLV(ctx context.Context, url, filename string, quit <-chan struct{}) (e error) {
+	defer func() {
+		if err := recover(); err != nil {
+			e = fmt.Errorf("recordFLV() error: %v", err)
+		}
+	}()
+
+	body, err := fetchStream(ctx, url)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	r := newFLVReader(body)
+	flags, err := r.readHeader()
+	if err != nil {
+		return fmt.Errorf("读取直播源的 flv header 失败：%w", err)
+	}
+	w, err := createFLV(filename, flags)
+	checkErr(err)
+	defer func() {
+		if err := w.close(); err != nil && e == nil {
+			e = err
+		}
+	}()
+
+	// 直播源一直有数据，所以每读取一个 tag 检查一次是否需要停止，卡住时连接会在 20 秒后超时
+	var ts flvTimestamp
+	for {
+		select {
+		case <-quit:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+		tag, err := r.readTag()
+		if err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return fmt.Errorf("直播源的连接已断开")
+			}
+			return err
+		}
+		tag.timestamp = ts.fix(tag)
+		if err = w.writeTag(tag); err != nil {
+			return err
+		}
+	}
+}
+
+// 修复意外中断的 flv 文件，截断最后一个完整的 tag 之后的数据，返回修复后的文件大小
+func repairFLV(filename string) (int64, error) {
+	f, err := os.OpenFile(filename, os.O_RDWR, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	header := make([]byte, flvHeaderSize)
+	if _, err = io.ReadFull(f, header); err != nil || !bytes.Equal(header[:3], []byte("FLV")) {
+		return 0, errFLVHeader
+	}
+	valid := int64(binary.BigEndian.Uint32(header[5:9])) + 4
+	if _, err = f.Seek(valid, io.SeekStart); err != nil {
+		return 0, err
+	}
+	r := newFLVReader(f)
+	for {
+		tag, err := r.readTag()
+		if err != nil {
+			break
+		}
+		valid += int64(len(tag.data)) + flvTagHeaderSize + 4
+	}
+	if err = f.Truncate(valid); err != nil {
+		return 0, err
+	}
+	return valid, nil
+}
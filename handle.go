@@ -15,6 +15,10 @@ const handleErrMsg = "请输入正确的命令，输入 help 查看全部命令
 const helpMsg = `listlive：列出正在直播的主播
 listrecord：列出正在下载的直播视频
 listdanmu：列出正在下载的直播弹幕
+listqueue：列出排队等待下载的直播视频
+listjob：列出录播的后期处理任务及其各步骤的状态
+listretention：预览按照保留规则将会删除的录播，不会删除文件
+history [uid [开始日期 [结束日期]]]：查看开播、下播、录播文件和 QQ 通知的历史记录，uid 为 0 时查看所有主播，日期格式为 2006-01-02
 startwebapi：启动 web API 服务器
 stopwebapi：停止 web API 服务器
 startwebui：启动 web UI 服务器，需要 web API 服务器运行，如果 web API 服务器没启动会启动 web API 服务器
@@ -48,6 +52,8 @@ startdanmu uid：临时下载指定主播的直播弹幕，如果没有设置自
 stopdanmu uid：正在下载指定主播的直播弹幕时取消下载
 startrecdan uid：临时下载指定主播的直播视频和弹幕），如果没有设置自动下载该主播的直播视频和弹幕，这次为一次性的下载
 stoprecdan uid：正在下载指定主播的直播视频和弹幕时取消下载
+startaudio uid：临时只下载指定主播的直播音频，这次为一次性的下载，用 stoprecord 取消下载
+clip uid 分钟数：无损剪辑指定主播正在下载的录播的最后几分钟，保存为单独的文件，通过 QQ 运行时文件不超过 100MB 会上传到 QQ
 quit：退出本程序，退出需要等待半分钟左右
 help：输出本帮助信息`
 
@@ -65,15 +71,15 @@ var uidBoolDispatch = map[string]func(int) bool{
 	"startdanmu":    startDanmu,
 	"stopdanmu":     stopDanmu,
 	"startrecdan":   startRecDan,
+	"startaudio":    startAudioRec,
 	"stoprecdan":    stopRecDan,
 	"cancelqq":      cancelQQNotify,
 	"cancelqqgroup": cancelQQGroup,
 }
 
 var listDispatch = map[string]func() []streaming{
-	"listlive":   listLive,
-	"listrecord": listRecord,
-	"listdanmu":  listDanmu,
+	"listlive":  listLive,
+	"listdanmu": listDanmu,
 }
 
 var qqDispatch = map[string]func(int, int64) bool{
@@ -96,6 +102,22 @@ func handleCmd(cmd string) string {
 	}
 
 	switch cmd {
+	case "listrecord":
+		data, err := json.MarshalIndent(listRecord(), "", "    ")
+		checkErr(err)
+		return string(data)
+	case "listretention":
+		data, err := json.MarshalIndent(listRetention(), "", "    ")
+		checkErr(err)
+		return string(data)
+	case "listqueue":
+		data, err := json.MarshalIndent(listQueue(), "", "    ")
+		checkErr(err)
+		return string(data)
+	case "listjob":
+		data, err := json.MarshalIndent(listJob(), "", "    ")
+		checkErr(err)
+		return string(data)
 	case "liststreamer":
 		data, err := json.MarshalIndent(getStreamers(), "", "    ")
 		checkErr(err)
@@ -180,6 +202,13 @@ func printErr() {
 // 处理所有命令
 func handleAllCmd(text string) string {
 	cmd := strings.Fields(text)
+	// history 的参数和其他命令不一样
+	if len(cmd) > 0 && cmd[0] == "history" {
+		return handleHistory(cmd[1:])
+	}
+	if len(cmd) > 0 && cmd[0] == "clip" {
+		return handleClip(cmd[1:])
+	}
 	switch len(cmd) {
 	case 1:
 		switch cmd[0] {
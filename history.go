@@ -0,0 +1,169 @@
+// 直播和录播历史记录相关
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 历史记录文件，每一行是一条 json 格式的记录，只会在文件末尾添加记录
+const historyFile = "history.jsonl"
+
+// 历史记录的类型
+const (
+	historyLiveOn  = "liveon"  // 主播开播
+	historyLiveOff = "liveoff" // 主播下播
+	historyRecord  = "record"  // 录播文件
+	historyDanmu   = "danmu"   // 单独下载的弹幕文件
+	historyNotify  = "notify"  // 发送 QQ 通知
+	historyStall   = "stall"   // 下载直播视频时超时没有下载到数据
+)
+
+// 历史记录的日期格式
+const historyDateLayout = "2006-01-02"
+
+// 历史记录
+type historyEntry struct {
+	Type    string `json:"type"`              // 记录类型，有 liveon、liveoff、record、danmu、notify 和 stall
+	Time    int64  `json:"time"`              // 记录时间，是以毫秒为单位的 Unix 时间
+	UID     int    `json:"uid"`               // 主播 uid
+	Name    string `json:"name"`              // 主播名字
+	LiveID  string `json:"liveID,omitempty"`  // 直播 ID
+	Title   string `json:"title,omitempty"`   // 直播间标题
+	Start   int64  `json:"start,omitempty"`   // 开始下载的时间，是以毫秒为单位的 Unix 时间
+	End     int64  `json:"end,omitempty"`     // 结束下载的时间，是以毫秒为单位的 Unix 时间
+	Video   string `json:"video,omitempty"`   // 录播文件
+	Danmu   string `json:"danmu,omitempty"`   // 弹幕文件
+	Target  string `json:"target,omitempty"`  // 通知的对象，如 qq 12345 或 qqgroup 12345
+	Message string `json:"message,omitempty"` // 通知的内容
+	Failed  bool   `json:"failed,omitempty"`  // 通知是否发送失败
+}
+
+// 写入历史记录文件的锁
+var historyMutex sync.Mutex
+
+// 添加历史记录
+func addHistory(e historyEntry) {
+	defer func() {
+		if err := recover(); err != nil {
+			lPrintErr("Recovering from panic in addHistory(), the error is:", err)
+			lPrintErr("添加历史记录时发生错误")
+		}
+	}()
+
+	if e.Time == 0 {
+		e.Time = time.Now().UnixMilli()
+	}
+	data, err := json.Marshal(e)
+	checkErr(err)
+
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+	f, err := os.OpenFile(filepath.Join(*configDir, historyFile), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	checkErr(err)
+	defer f.Close()
+	_, err = f.Write(append(data, '\n'))
+	checkErr(err)
+}
+
+// 查询历史记录，uid 为 0 时查询所有主播，from 和 to 为零值时不限制时间
+func queryHistory(uid int, from, to time.Time) ([]historyEntry, error) {
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+	list := []historyEntry{}
+	f, err := os.Open(filepath.Join(*configDir, historyFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return list, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e historyEntry
+		// 跳过程序崩溃时没有写完的记录
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue
+		}
+		if uid != 0 && e.UID != uid {
+			continue
+		}
+		if !from.IsZero() && e.Time < from.UnixMilli() {
+			continue
+		}
+		if !to.IsZero() && e.Time >= to.UnixMilli() {
+			continue
+		}
+		list = append(list, e)
+	}
+	return list, scanner.Err()
+}
+
+// 处理 history 命令的参数，参数为 [uid [开始日期 [结束日期]]]，日期格式为 2006-01-02，结束日期当天的记录也包括在内
+func handleHistory(args []string) string {
+	var uid int
+	var from, to time.Time
+	var err error
+	if len(args) > 3 {
+		printErr()
+		return ""
+	}
+	if len(args) >= 1 {
+		if uid, err = strconv.Atoi(args[0]); err != nil || uid < 0 {
+			printErr()
+			return ""
+		}
+	}
+	if len(args) >= 2 {
+		if from, err = time.ParseInLocation(historyDateLayout, args[1], time.Local); err != nil {
+			lPrintErrf("日期 %s 的格式必须是 %s", args[1], historyDateLayout)
+			return ""
+		}
+	}
+	if len(args) == 3 {
+		if to, err = time.ParseInLocation(historyDateLayout, args[2], time.Local); err != nil {
+			lPrintErrf("日期 %s 的格式必须是 %s", args[2], historyDateLayout)
+			return ""
+		}
+		to = to.AddDate(0, 0, 1)
+	}
+	return historyJSON(uid, from, to)
+}
+
+// 返回 json 格式的历史记录
+func historyJSON(uid int, from, to time.Time) string {
+	list, err := queryHistory(uid, from, to)
+	if err != nil {
+		lPrintErrf("读取历史记录失败：%v", err)
+		return ""
+	}
+	data, err := json.MarshalIndent(list, "", "    ")
+	checkErr(err)
+	return string(data)
+}
+
+// 通知对象的字符串
+func notifyTarget(kind string, id int64) string {
+	return fmt.Sprintf("%s %d", kind, id)
+}
+
+// 添加发送通知的历史记录
+func (s *streamer) addNotifyHistory(target, text string, ok bool) {
+	addHistory(historyEntry{
+		Type:    historyNotify,
+		UID:     s.UID,
+		Name:    s.Name,
+		Target:  target,
+		Message: text,
+		Failed:  !ok,
+	})
+}
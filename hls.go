@@ -0,0 +1,414 @@
+// hls 相关
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	hlsWorkers       = 3                // 同时下载的分片数量
+	hlsSegmentRetry  = 3                // 分片下载失败时的重试次数
+	hlsResumeTimeout = 60 * time.Second // 获取 m3u8 连续失败超过这个时间时停止下载
+)
+
+// m3u8 里的分片
+type hlsSegment struct {
+	seq           int64   // media sequence
+	url           string  // 分片链接
+	duration      float64 // 分片时长，单位为秒
+	discontinuity bool    // 分片前是否有 EXT-X-DISCONTINUITY
+}
+
+// m3u8 播放列表
+type hlsPlaylist struct {
+	targetDuration float64      // EXT-X-TARGETDURATION
+	segments       []hlsSegment // 分片列表
+	endList        bool         // 是否有 EXT-X-ENDLIST
+	variants       []hlsVariant // master playlist 里的子播放列表
+}
+
+// master playlist 里的子播放列表
+type hlsVariant struct {
+	bandwidth int
+	url       string
+}
+
+// hls 下载的统计数据
+type hlsStats struct {
+	sync.Mutex
+	Segments        int64   `json:"segments"`        // 已下载的分片数量
+	Missing         int64   `json:"missing"`         // 缺失的分片数量
+	Discontinuities int64   `json:"discontinuities"` // EXT-X-DISCONTINUITY 的数量
+	Resumes         int64   `json:"resumes"`         // 网络中断后恢复下载的次数
+	Bytes           int64   `json:"bytes"`           // 已写入的字节数
+	Duration        float64 `json:"duration"`        // 已下载的分片总时长，单位为秒
+	LastSequence    int64   `json:"lastSequence"`    // 最后下载的分片的 media sequence
+}
+
+// 返回统计数据的副本
+func (st *hlsStats) copy() *hlsStats {
+	st.Lock()
+	defer st.Unlock()
+	return &hlsStats{
+		Segments:        st.Segments,
+		Missing:         st.Missing,
+		Discontinuities: st.Discontinuities,
+		Resumes:         st.Resumes,
+		Bytes:           st.Bytes,
+		Duration:        st.Duration,
+		LastSequence:    st.LastSequence,
+	}
+}
+
+// 解析 m3u8
+func parseM3U8(data []byte, baseURL string) (*hlsPlaylist, error) {
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	resolve := func(ref string) (string, error) {
+		u, err := url.Parse(ref)
+		if err != nil {
+			return "", err
+		}
+		return base.ResolveReference(u).String(), nil
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	if !scanner.Scan() || !strings.HasPrefix(strings.TrimSpace(scanner.Text()), "#EXTM3U") {
+		return nil, fmt.Errorf("不是有效的 m3u8")
+	}
+
+	pl := new(hlsPlaylist)
+	var seq int64
+	var duration float64
+	var discontinuity bool
+	var bandwidth int
+	var isVariant bool
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#EXT-X-TARGETDURATION:"):
+			pl.targetDuration, _ = strconv.ParseFloat(line[len("#EXT-X-TARGETDURATION:"):], 64)
+		case strings.HasPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"):
+			seq, err = strconv.ParseInt(line[len("#EXT-X-MEDIA-SEQUENCE:"):], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("无效的 EXT-X-MEDIA-SEQUENCE：%s", line)
+			}
+		case strings.HasPrefix(line, "#EXTINF:"):
+			value := line[len("#EXTINF:"):]
+			if i := strings.IndexByte(value, ','); i >= 0 {
+				value = value[:i]
+			}
+			duration, _ = strconv.ParseFloat(value, 64)
+		case line == "#EXT-X-DISCONTINUITY":
+			discontinuity = true
+		case line == "#EXT-X-ENDLIST":
+			pl.endList = true
+		case strings.HasPrefix(line, "#EXT-X-MAP:"):
+			return nil, fmt.Errorf("不支持 fmp4 分片")
+		case strings.HasPrefix(line, "#EXT-X-KEY:") && !strings.Contains(line, "METHOD=NONE"):
+			return nil, fmt.Errorf("不支持加密的分片")
+		case strings.HasPrefix(line, "#EXT-X-STREAM-INF:"):
+			isVariant = true
+			bandwidth = 0
+			for _, attr := range strings.Split(line[len("#EXT-X-STREAM-INF:"):], ",") {
+				if v, ok := strings.CutPrefix(attr, "BANDWIDTH="); ok {
+					bandwidth, _ = strconv.Atoi(v)
+				}
+			}
+		case strings.HasPrefix(line, "#"):
+		default:
+			u, err := resolve(line)
+			if err != nil {
+				return nil, err
+			}
+			if isVariant {
+				pl.variants = append(pl.variants, hlsVariant{bandwidth: bandwidth, url: u})
+				isVariant = false
+				continue
+			}
+			pl.segments = append(pl.segments, hlsSegment{
+				seq:           seq,
+				url:           u,
+				duration:      duration,
+				discontinuity: discontinuity,
+			})
+			seq++
+			duration = 0
+			discontinuity = false
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return pl, nil
+}
+
+// 获取 hls 的 m3u8 或分片，会自动跳转，返回 body 和最终的链接
+func fetchHLS(link string) (body []byte, finalURL string, e error) {
+	defer func() {
+		if err := recover(); err != nil {
+			e = fmt.Errorf("fetchHLS() error: %v", err)
+		}
+	}()
+
+	for redirect := 0; redirect < 5; redirect++ {
+		client := &httpClient{
+			url:    link,
+			method: fasthttp.MethodGet,
+		}
+		resp, err := client.doRequest()
+		if err != nil {
+			return nil, "", err
+		}
+		code := resp.StatusCode()
+		switch {
+		case code == fasthttp.StatusOK:
+			body = append([]byte{}, getBody(resp)...)
+			fasthttp.ReleaseResponse(resp)
+			return body, link, nil
+		case fasthttp.StatusCodeIsRedirect(code):
+			location := string(resp.Header.Peek(fasthttp.HeaderLocation))
+			fasthttp.ReleaseResponse(resp)
+			base, err := url.Parse(link)
+			checkErr(err)
+			ref, err := url.Parse(location)
+			checkErr(err)
+			link = base.ResolveReference(ref).String()
+		default:
+			fasthttp.ReleaseResponse(resp)
+			return nil, "", fmt.Errorf("请求 %s 时响应的状态码为 %d", link, code)
+		}
+	}
+
+	return nil, "", fmt.Errorf("请求 %s 时跳转次数过多", link)
+}
+
+// 获取并解析 m3u8，master playlist 会选择码率最高的子播放列表
+func fetchPlaylist(link string) (*hlsPlaylist, string, error) {
+	for i := 0; i < 3; i++ {
+		body, finalURL, err := fetchHLS(link)
+		if err != nil {
+			return nil, "", err
+		}
+		pl, err := parseM3U8(body, finalURL)
+		if err != nil {
+			return nil, "", err
+		}
+		if len(pl.variants) == 0 {
+			return pl, finalURL, nil
+		}
+		best := pl.variants[0]
+		for _, v := range pl.variants[1:] {
+			if v.bandwidth > best.bandwidth {
+				best = v
+			}
+		}
+		link = best.url
+	}
+	return nil, "", fmt.Errorf("m3u8 嵌套层数过多")
+}
+
+// 下载分片，失败时重试
+func downloadSegment(ctx context.Context, seg hlsSegment) (data []byte, err error) {
+	for retry := 0; retry < hlsSegmentRetry; retry++ {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if data, _, err = fetchHLS(seg.url); err == nil {
+			return data, nil
+		}
+		time.Sleep(time.Second)
+	}
+	return nil, err
+}
+
+// 使用内置的 hls 下载器下载直播视频，quit 被关闭时正常结束下载，网络短暂中断时会继续写入同一个文件
+func recordHLS(ctx context.Context, link, filename string, quit <-chan struct{}, stats *hlsStats, logID string) (e error) {
+	defer func() {
+		if err := recover(); err != nil {
+			e = fmt.Errorf("recordHLS() error: %v", err)
+		}
+	}()
+
+	f, err := os.Create(filename)
+	checkErr(err)
+	w := bufio.NewWriterSize(f, 1024*1024)
+	defer func() {
+		err := w.Flush()
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil && e == nil {
+			e = err
+		}
+	}()
+
+	lastSeq := int64(-1)
+	lastSuccess := time.Now()
+	var failed bool
+	for {
+		select {
+		case <-quit:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		pl, finalURL, err := fetchPlaylist(link)
+		if err != nil {
+			if time.Since(lastSuccess) > hlsResumeTimeout {
+				return fmt.Errorf("超过 %v 无法获取 m3u8：%w", hlsResumeTimeout, err)
+			}
+			if !failed {
+				lPrintWarnf("获取%s的 m3u8 失败，尝试恢复下载：%v", logID, err)
+			}
+			failed = true
+			time.Sleep(2 * time.Second)
+			continue
+		}
+		if failed {
+			failed = false
+			stats.Lock()
+			stats.Resumes++
+			stats.Unlock()
+			lPrintf("恢复下载%s的 hls 直播源，中断了 %v", logID, time.Since(lastSuccess).Round(time.Second))
+		}
+		lastSuccess = time.Now()
+		link = finalURL
+
+		var segments []hlsSegment
+		if len(pl.segments) != 0 {
+			first := pl.segments[0].seq
+			last := pl.segments[len(pl.segments)-1].seq
+			switch {
+			case lastSeq >= 0 && last < lastSeq-int64(len(pl.segments)):
+				// media sequence 被重置，直播源可能重新推流
+				lPrintWarnf("%s的 hls 直播源的 media sequence 从 %d 重置为 %d", logID, lastSeq, first)
+				stats.Lock()
+				stats.Discontinuities++
+				stats.Unlock()
+				lastSeq = first - 1
+			case lastSeq >= 0 && first > lastSeq+1:
+				missing := first - lastSeq - 1
+				lPrintWarnf("%s的 hls 直播源缺失了 %d 个分片（%d 到 %d）", logID, missing, lastSeq+1, first-1)
+				stats.Lock()
+				stats.Missing += missing
+				stats.Unlock()
+			}
+			for _, seg := range pl.segments {
+				if seg.seq > lastSeq {
+					segments = append(segments, seg)
+				}
+			}
+		}
+
+		// 并发下载分片，按顺序写入
+		results := make([][]byte, len(segments))
+		sem := make(chan struct{}, hlsWorkers)
+		var wg sync.WaitGroup
+		for i, seg := range segments {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(i int, seg hlsSegment) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				data, err := downloadSegment(ctx, seg)
+				if err != nil {
+					lPrintErrf("下载%s的 hls 分片 %d 失败：%v", logID, seg.seq, err)
+					return
+				}
+				results[i] = data
+			}(i, seg)
+		}
+		wg.Wait()
+
+		for i, seg := range segments {
+			if seg.discontinuity {
+				lPrintWarnf("%s的 hls 直播源在分片 %d 前不连续", logID, seg.seq)
+			}
+			stats.Lock()
+			if seg.discontinuity {
+				stats.Discontinuities++
+			}
+			if results[i] == nil {
+				stats.Missing++
+			} else {
+				stats.Segments++
+				stats.Bytes += int64(len(results[i]))
+				stats.Duration += seg.duration
+			}
+			stats.LastSequence = seg.seq
+			stats.Unlock()
+			if results[i] != nil {
+				if _, err := w.Write(results[i]); err != nil {
+					return err
+				}
+			}
+			lastSeq = seg.seq
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+
+		if pl.endList {
+			lPrintln(logID + "的 hls 直播源已经结束")
+			return nil
+		}
+
+		// 按照 EXT-X-TARGETDURATION 的一半刷新 m3u8
+		interval := time.Duration(pl.targetDuration * float64(time.Second) / 2)
+		if interval < time.Second {
+			interval = time.Second
+		}
+		select {
+		case <-quit:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// mpeg-ts 包的长度
+const tsPacketSize = 188
+
+// 修复意外中断的 ts 文件，截断最后一个完整的 ts 包之后的数据，返回修复后的文件大小
+func repairTS(filename string) (int64, error) {
+	f, err := os.OpenFile(filename, os.O_RDWR, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReaderSize(f, 64*1024)
+	packet := make([]byte, tsPacketSize)
+	var valid int64
+	for {
+		if _, err := io.ReadFull(r, packet); err != nil || packet[0] != 0x47 {
+			break
+		}
+		valid += tsPacketSize
+	}
+	if err = f.Truncate(valid); err != nil {
+		return 0, err
+	}
+	return valid, nil
+}
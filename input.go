@@ -4,6 +4,7 @@ package main
 import (
 	"bufio"
 	"encoding/json"
+	"fmt"
 	"log"
 	"os"
 	"sort"
@@ -21,6 +22,17 @@ type sJSON struct {
 	URL   string `json:"url"`
 }
 
+// 正在下载的直播视频
+type recordingJSON struct {
+	sJSON
+	LiveID   string          `json:"liveID"`             // 直播 ID
+	Quality  string          `json:"quality"`            // 下载的直播源画质
+	File     string          `json:"file"`               // 录播文件路径
+	Recorder string          `json:"recorder"`           // 下载直播视频的方式
+	HLS      *hlsStats       `json:"hls,omitempty"`      // 内置 hls 下载器的统计数据
+	Progress *ffmpegProgress `json:"progress,omitempty"` // FFmpeg 的下载进度
+}
+
 // 实现 json.Marshaler 接口
 func (s *streaming) MarshalJSON() ([]byte, error) {
 	st := (*streamer)(s)
@@ -55,27 +67,60 @@ func listLive() (streamings []streaming) {
 }
 
 // 列出正在下载的直播视频
-func listRecord() (recordings []streaming) {
+func listRecord() (recordings []recordingJSON) {
 	lInfoMap.RLock()
-	recordings = make([]streaming, 0, len(lInfoMap.info))
+	infoList := make([]liveInfo, 0, len(lInfoMap.info))
 	for _, info := range lInfoMap.info {
 		if info.isRecording {
-			recordings = append(recordings, streaming{
-				UID:  info.uid,
-				Name: getName(info.uid),
-			})
+			infoList = append(infoList, info)
 		}
 	}
 	lInfoMap.RUnlock()
 
+	recordings = make([]recordingJSON, 0, len(infoList))
+	for _, info := range infoList {
+		s := streamer{UID: info.uid, Name: getName(info.uid)}
+		title := s.getTitle()
+		// 同一场直播的每个画质都单独列出
+		for _, rec := range info.recordings {
+			r := recordingJSON{
+				sJSON:    sJSON{UID: s.UID, Name: s.Name, Title: title, URL: s.getURL()},
+				LiveID:   info.LiveID,
+				Quality:  rec.quality,
+				File:     rec.recordFile,
+				Recorder: rec.recorder,
+			}
+			if rec.hlsStats != nil {
+				r.HLS = rec.hlsStats.copy()
+			}
+			if rec.progress != nil {
+				r.Progress = rec.progress.copy()
+			}
+			recordings = append(recordings, r)
+		}
+	}
+
 	sort.Slice(recordings, func(i, j int) bool {
-		return recordings[i].UID < recordings[j].UID
+		if recordings[i].UID != recordings[j].UID {
+			return recordings[i].UID < recordings[j].UID
+		}
+		return recordings[i].File < recordings[j].File
 	})
 	if *isNoGUI {
 		log.Println("正在下载的直播视频：")
 		for _, r := range recordings {
-			s := streamer(r)
-			log.Println(s.longID() + "：" + s.getTitle() + " " + s.getURL())
+			msg := fmt.Sprintf("%s（%d）：%s %s，画质：%s", r.Name, r.UID, r.Title, r.URL, r.Quality)
+			if r.HLS != nil {
+				msg += fmt.Sprintf("，已下载 %d 个分片，缺失 %d 个分片，不连续 %d 次", r.HLS.Segments, r.HLS.Missing, r.HLS.Discontinuities)
+			}
+			if p := r.Progress; p != nil {
+				msg += fmt.Sprintf("，已下载 %s，%.1fMB，码率 %.1fkbit/s，速度 %.2fx，丢帧 %d，重复帧 %d",
+					time.Duration(p.Elapsed*float64(time.Second)).Round(time.Second), float64(p.Bytes)/1024/1024, p.Bitrate, p.Speed, p.DropFrames, p.DupFrames)
+				if p.LastUpdate != 0 {
+					msg += fmt.Sprintf("，%s前更新", time.Since(time.UnixMilli(p.LastUpdate)).Round(time.Second))
+				}
+			}
+			log.Println(msg)
 		}
 	}
 
@@ -0,0 +1,161 @@
+// 未完成录播的记录相关
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// 记录正在下载的录播文件，用来在程序崩溃后修复录播文件
+const journalFile = "recording.json"
+
+// 正在下载的录播文件
+type journalEntry struct {
+	File   string `json:"file"`   // 录播文件路径
+	Danmu  string `json:"danmu"`  // 弹幕文件路径，没有下载弹幕时为空
+	UID    int    `json:"uid"`    // 主播 uid
+	Name   string `json:"name"`   // 主播名字
+	LiveID string `json:"liveID"` // 直播 ID
+	Start  int64  `json:"start"`  // 开始下载的时间，是以秒为单位的 Unix 时间
+}
+
+// 正在下载的录播文件的记录
+var journal struct {
+	sync.Mutex
+	entries []journalEntry
+	orphans []journalEntry // 上次运行时没有处理的录播文件
+}
+
+// 读取 recording.json
+func loadJournal() {
+	journal.Lock()
+	defer journal.Unlock()
+	data, err := os.ReadFile(filepath.Join(*configDir, journalFile))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			lPrintErrf("读取 %s 失败：%v", journalFile, err)
+		}
+		return
+	}
+	if err = json.Unmarshal(data, &journal.entries); err != nil {
+		lPrintErrf("%s 的内容不符合 json 格式：%v", journalFile, err)
+		journal.entries = nil
+		return
+	}
+	journal.orphans = append([]journalEntry(nil), journal.entries...)
+}
+
+// 保存 recording.json，需要锁住 journal
+func saveJournal() {
+	data, err := json.MarshalIndent(journal.entries, "", "    ")
+	checkErr(err)
+	// 先写入临时文件再重命名，防止写入一半时程序崩溃
+	file := filepath.Join(*configDir, journalFile)
+	err = os.WriteFile(file+".tmp", data, 0644)
+	checkErr(err)
+	err = os.Rename(file+".tmp", file)
+	checkErr(err)
+}
+
+// 记录开始下载的录播文件，danmu 为对应的弹幕文件，可以为空
+func addJournal(s *streamer, liveID, file, danmu string) {
+	journal.Lock()
+	defer journal.Unlock()
+	journal.entries = append(journal.entries, journalEntry{
+		File:   file,
+		Danmu:  danmu,
+		UID:    s.UID,
+		Name:   s.Name,
+		LiveID: liveID,
+		Start:  time.Now().Unix(),
+	})
+	saveJournal()
+}
+
+// 删除已经处理完的录播文件的记录
+func removeJournal(file string) {
+	journal.Lock()
+	defer journal.Unlock()
+	for i, e := range journal.entries {
+		if e.File == file {
+			journal.entries = append(journal.entries[:i], journal.entries[i+1:]...)
+			saveJournal()
+			return
+		}
+	}
+}
+
+// 修复上次运行时因程序崩溃而没有处理的录播文件，并转换格式和移动文件
+func repairOrphans() {
+	defer func() {
+		if err := recover(); err != nil {
+			lPrintErr("Recovering from panic in repairOrphans(), the error is:", err)
+			lPrintErr("修复上次运行时没有处理的录播文件时发生错误")
+		}
+	}()
+
+	journal.Lock()
+	orphans := journal.orphans
+	journal.orphans = nil
+	journal.Unlock()
+	for _, e := range orphans {
+		if _, err := os.Stat(e.File); err != nil {
+			removeJournal(e.File)
+			continue
+		}
+		lPrintf("发现上次运行时没有处理的%s的录播文件 %s，尝试修复", e.Name, e.File)
+		if err := repairFile(e.File); err != nil {
+			lPrintErrf("修复录播文件 %s 失败：%v", e.File, err)
+		}
+		// 以文件的修改时间作为结束下载的时间
+		if info, err := os.Stat(e.File); err == nil {
+			updateSidecar(sidecarFile(e.File), func(sc *sidecar) {
+				if sc.End == 0 {
+					sc.End = info.ModTime().UnixMilli()
+					sc.EndReason = reasonInterrupted
+				}
+			})
+		}
+		s, ok := getStreamer(e.UID)
+		if !ok {
+			s = streamer{UID: e.UID, Name: e.Name}
+		}
+		s.postRecord(e.LiveID, e.File, e.orphanDanmu())
+	}
+}
+
+// 录播文件对应的弹幕文件，旧的记录里没有时从元数据文件里读取，文件不存在时返回空字符串
+func (e *journalEntry) orphanDanmu() string {
+	danmu := e.Danmu
+	if danmu == "" {
+		if sc, err := readSidecar(sidecarFile(e.File)); err == nil && sc.Danmu != "" {
+			danmu = filepath.Join(filepath.Dir(e.File), sc.Danmu)
+		}
+	}
+	if danmu == "" || !isFileExist(danmu) {
+		return ""
+	}
+	return danmu
+}
+
+// 根据后缀名修复录播文件
+func repairFile(file string) error {
+	var size int64
+	var err error
+	switch filepath.Ext(file) {
+	case ".flv":
+		size, err = repairFLV(file)
+	case ".ts":
+		size, err = repairTS(file)
+	default:
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	lPrintf("录播文件 %s 修复后的大小为 %d 字节", file, size)
+	return nil
+}
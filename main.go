@@ -125,6 +125,10 @@ func checkConfig() {
 		lPrintErr(configFile + "里的 source 必须是 hls 或 flv")
 		os.Exit(1)
 	}
+	if config.Recorder != autoRecorder && config.Recorder != ffmpegRecorder && config.Recorder != nativeRecorder {
+		lPrintErr(configFile + "里的 recorder 必须是 auto、ffmpeg 或 native")
+		os.Exit(1)
+	}
 	if config.WebPort < 1024 || config.WebPort > 65525 {
 		lPrintErr(configFile + "里的 webPort 必须大于 1023 且少于 65526")
 		os.Exit(1)
@@ -137,6 +141,10 @@ func checkConfig() {
 			os.Exit(1)
 		}
 	}
+	if err := checkPipeline(config.Pipeline, false); err != nil {
+		lPrintErrf("%s里的 pipeline 设置错误：%v", configFile, err)
+		os.Exit(1)
+	}
 	if config.Mirai.AdminQQ < 0 || config.Mirai.BotQQ < 0 {
 		lPrintErr(configFile + "里的 QQ 号必须大于等于 0")
 		os.Exit(1)
@@ -194,6 +202,8 @@ func initialize() {
 	loadLiveConfig()
 	loadConfig()
 	checkConfig()
+	checkFFmpeg()
+	loadJournal()
 
 	for uid, s := range streamers.crt {
 		streamers.old[uid] = s
@@ -250,6 +260,8 @@ func main() {
 			}
 		}
 
+		go repairOrphans()
+
 		for _, s := range streamers.crt {
 			go s.cycle("")
 		}
@@ -261,6 +273,9 @@ func main() {
 		go cycleConfig(ctx)
 		go cycleFetch(ctx)
 		go cycleDelKey(ctx)
+		go cycleDiskSpace(ctx)
+		go cycleRetention(ctx)
+		go cycleSchedule(ctx)
 
 		// 启动 GUI 时不需要处理命令输入
 		if *isNoGUI {
@@ -317,9 +332,9 @@ func main() {
 				lInfoMap.RLock()
 				for _, info := range lInfoMap.info {
 					// 结束下载直播视频
-					if info.isRecording {
-						info.recordCh <- stopRecord
-						_, _ = io.WriteString(info.ffmpegStdin, "q")
+					for _, rec := range info.recordings {
+						rec.recordCh <- stopRecord
+						_, _ = io.WriteString(rec.ffmpegStdin, "q")
 					}
 					// 结束下载弹幕
 					if info.isDanmu {
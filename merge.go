@@ -0,0 +1,271 @@
+// 合并重启下载产生的录播分段相关
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// 合并后的文件大小至少是原文件总大小的比例，小于时认为合并失败
+const mergeMinRatio = 0.9
+
+// 合并后的时长和各分段时长之和相差超过这个时长和 1-mergeMinRatio 的比例时认为合并失败
+const mergeMaxDurationDiff = 10 * time.Second
+
+// 需要合并的录播分段
+type mergePart struct {
+	recordFile string          // 录播文件
+	assFile    *string         // 弹幕文件，分段的弹幕下载结束后才会设置
+	done       <-chan struct{} // 分段的弹幕下载结束时会关闭
+	duration   time.Duration   // 录播的时长
+}
+
+// 等待分段下载结束后合并分段，合并失败时分别处理各个分段
+func (s *streamer) mergeParts(liveID string, parts []mergePart) {
+	defer func() {
+		if err := recover(); err != nil {
+			lPrintErr("Recovering from panic in mergeParts(), the error is:", err)
+			lPrintErrf("合并%s的录播分段时发生错误", s.longID())
+		}
+	}()
+
+	for _, p := range parts {
+		<-p.done
+	}
+	// 没有 FFmpeg 时无法合并，启动时已经提醒过
+	if len(parts) == 1 || ffmpegMissing {
+		for _, p := range parts {
+			s.postRecord(liveID, p.recordFile, *p.assFile)
+		}
+		return
+	}
+
+	video, ass, err := concatParts(parts)
+	if err != nil {
+		lPrintErrf("合并%s的%d个录播分段失败，保留原文件并分别处理：%v", s.longID(), len(parts), err)
+		for _, p := range parts {
+			s.postRecord(liveID, p.recordFile, *p.assFile)
+		}
+		return
+	}
+	lPrintf("成功将%s的%d个录播分段合并为 %s", s.longID(), len(parts), video)
+	s.postRecord(liveID, video, ass)
+}
+
+// 无损合并录播分段和对应的弹幕文件，合并后的文件使用第一个分段的文件名，验证合并成功后才删除原文件
+func concatParts(parts []mergePart) (video, ass string, e error) {
+	var files []string
+	var total int64
+	// 实际合并的分段的总时长
+	var duration time.Duration
+	// 各分段的弹幕时间偏移，为前面实际合并的分段的总时长
+	offsets := make([]time.Duration, len(parts))
+	for i, p := range parts {
+		offsets[i] = duration
+		if info, err := os.Stat(p.recordFile); err == nil && info.Size() > 0 {
+			files = append(files, p.recordFile)
+			total += info.Size()
+			duration += p.duration
+		}
+	}
+	if len(files) == 0 {
+		return "", "", errors.New("没有可以合并的录播文件")
+	}
+
+	first := parts[0].recordFile
+	ext := filepath.Ext(first)
+	base := strings.TrimSuffix(first, ext)
+	mergedVideo := base + ".merged" + ext
+	listFile := base + ".merged.txt"
+	var list strings.Builder
+	for _, file := range files {
+		abs, err := filepath.Abs(file)
+		if err != nil {
+			return "", "", err
+		}
+		// concat 格式的文件名里的单引号需要转义
+		fmt.Fprintf(&list, "file '%s'\n", strings.ReplaceAll(filepath.ToSlash(abs), "'", `'\''`))
+	}
+	if err := os.WriteFile(listFile, []byte(list.String()), 0644); err != nil {
+		return "", "", err
+	}
+	defer os.Remove(listFile)
+
+	// 合并结束时 progress.Elapsed 为合并后的文件时长
+	progress := new(ffmpegProgress)
+	if err := runFFmpegProgress(progress, "-f", "concat", "-safe", "0", "-i", listFile, "-c", "copy", mergedVideo); err != nil {
+		_ = os.Remove(mergedVideo)
+		return "", "", err
+	}
+	info, err := os.Stat(mergedVideo)
+	if err != nil {
+		return "", "", err
+	}
+	if float64(info.Size()) < float64(total)*mergeMinRatio {
+		_ = os.Remove(mergedVideo)
+		return "", "", fmt.Errorf("合并后的文件大小 %d 字节小于原文件总大小 %d 字节", info.Size(), total)
+	}
+	merged := time.Duration(progress.copy().Elapsed * float64(time.Second))
+	diff := merged - duration
+	if diff < 0 {
+		diff = -diff
+	}
+	if diff > mergeMaxDurationDiff && float64(diff) > float64(duration)*(1-mergeMinRatio) {
+		_ = os.Remove(mergedVideo)
+		return "", "", fmt.Errorf("合并后的文件时长 %v 和原文件总时长 %v 不一致", merged.Round(time.Second), duration.Round(time.Second))
+	}
+
+	// 合并弹幕文件，每个分段的弹幕时间加上前面实际合并的分段的总时长
+	var assFiles []string
+	var assOffsets []time.Duration
+	for i, p := range parts {
+		if *p.assFile != "" && isFileExist(*p.assFile) {
+			assFiles = append(assFiles, *p.assFile)
+			assOffsets = append(assOffsets, offsets[i])
+		}
+	}
+	mergedASS := ""
+	if len(assFiles) > 0 {
+		mergedASS = base + ".merged.ass"
+		if err := mergeASS(assFiles, assOffsets, mergedASS); err != nil {
+			_ = os.Remove(mergedVideo)
+			_ = os.Remove(mergedASS)
+			return "", "", fmt.Errorf("合并弹幕文件失败：%w", err)
+		}
+	}
+
+	// 合并成功，删除原文件，最后一个分段的元数据需要在删除前读取
+	lastSC, err := readSidecar(sidecarFile(parts[len(parts)-1].recordFile))
+	if err != nil {
+		lPrintErrf("读取元数据文件失败：%v", err)
+	}
+	for i, p := range parts {
+		if err := os.Remove(p.recordFile); err != nil && !os.IsNotExist(err) {
+			lPrintErrf("删除文件 %s 失败：%v", p.recordFile, err)
+		}
+		if *p.assFile != "" {
+			if err := os.Remove(*p.assFile); err != nil && !os.IsNotExist(err) {
+				lPrintErrf("删除文件 %s 失败：%v", *p.assFile, err)
+			}
+		}
+		if i > 0 {
+			_ = os.Remove(sidecarFile(p.recordFile))
+			removeJournal(p.recordFile)
+		}
+	}
+	if err := os.Rename(mergedVideo, first); err != nil {
+		return "", "", err
+	}
+	if mergedASS != "" {
+		ass = base + ".ass"
+		if err := os.Rename(mergedASS, ass); err != nil {
+			return "", "", err
+		}
+	}
+	updateMergedSidecar(parts, ass, lastSC)
+	return first, ass, nil
+}
+
+// 将最后一个分段的结束时间等写入第一个分段的元数据文件
+func updateMergedSidecar(parts []mergePart, ass string, lastSC *sidecar) {
+	merged := make([]string, 0, len(parts))
+	for _, p := range parts {
+		merged = append(merged, filepath.Base(p.recordFile))
+	}
+	updateSidecar(sidecarFile(parts[0].recordFile), func(sc *sidecar) {
+		if lastSC != nil {
+			sc.End = lastSC.End
+			sc.EndReason = lastSC.EndReason
+			sc.Restarts = lastSC.Restarts
+			sc.Stalls = lastSC.Stalls
+			sc.FailedQualities = lastSC.FailedQualities
+		}
+		if ass != "" {
+			sc.Danmu = filepath.Base(ass)
+		}
+		sc.MergedParts = merged
+	})
+}
+
+// ass 文件里的时间格式为 H:MM:SS.cc
+func parseASSTime(t string) (time.Duration, error) {
+	var h, m, sec, cs int
+	if _, err := fmt.Sscanf(t, "%d:%d:%d.%d", &h, &m, &sec, &cs); err != nil {
+		return 0, err
+	}
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(sec)*time.Second + time.Duration(cs)*10*time.Millisecond, nil
+}
+
+// 将时间转换为 ass 文件里的时间格式
+func formatASSTime(d time.Duration) string {
+	cs := int64(d / (10 * time.Millisecond))
+	return fmt.Sprintf("%d:%02d:%02d.%02d", cs/360000, cs/6000%60, cs/100%60, cs%100)
+}
+
+// 合并 ass 文件，使用第一个文件的 Script Info 和 Styles，其他文件只取 Dialogue 并加上对应的时间偏移
+func mergeASS(files []string, offsets []time.Duration, out string) error {
+	var b strings.Builder
+	for i, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if !strings.HasPrefix(line, "Dialogue:") {
+				if i == 0 {
+					b.WriteString(line + "\n")
+				}
+				continue
+			}
+			b.WriteString(shiftDialogue(line, offsets[i]) + "\n")
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(out, []byte(b.String()), 0644)
+}
+
+// 将 Dialogue 的开始和结束时间加上 offset，格式不对时原样返回
+func shiftDialogue(line string, offset time.Duration) string {
+	if offset == 0 {
+		return line
+	}
+	fields := strings.SplitN(line, ",", 4)
+	if len(fields) != 4 {
+		return line
+	}
+	for i := 1; i <= 2; i++ {
+		t, err := parseASSTime(fields[i])
+		if err != nil {
+			return line
+		}
+		fields[i] = formatASSTime(t + offset)
+	}
+	return strings.Join(fields, ",")
+}
+
+// 录播分段的时长，优先使用下载器统计的时长，没有时使用下载的时间
+func partDuration(rec *recording, start time.Time) time.Duration {
+	if rec.progress != nil {
+		if p := rec.progress.copy(); p.Elapsed > 0 {
+			return time.Duration(p.Elapsed * float64(time.Second))
+		}
+	}
+	if rec.hlsStats != nil {
+		if st := rec.hlsStats.copy(); st.Duration > 0 {
+			return time.Duration(st.Duration * float64(time.Second))
+		}
+	}
+	return time.Since(start)
+}
@@ -424,10 +424,17 @@ func handlePrivateMsg(qq int64, Elements []message.IMessageElement) {
 			if e, ok := ele.(*message.TextElement); ok {
 				text := e.Content
 				lPrintf("处理来自 QQ %d 的命令：%s", qq, text)
+				if cmd := strings.Fields(text); len(cmd) > 0 && cmd[0] == "clip" {
+					// 剪辑可能需要较长时间，剪辑的文件要上传到发送命令的 QQ
+					go func(args []string) {
+						miraiSendQQ(qq, miraiClip(message.Source{SourceType: message.SourcePrivate, PrimaryID: qq}, args), nil)
+					}(cmd[1:])
+					continue
+				}
 				if s := handleAllCmd(text); s != "" {
-					miraiSendQQ(qq, s)
+					miraiSendQQ(qq, s, nil)
 				} else {
-					miraiSendQQ(qq, handleErrMsg)
+					miraiSendQQ(qq, handleErrMsg, nil)
 				}
 			}
 		}
@@ -452,76 +459,104 @@ func groupMsgEvent(c *client.QQClient, m *message.GroupMessage) {
 		if isAt {
 			cmd := strings.Join(text, "")
 			lPrintf("处理来自QQ群 %d 里QQ %d 的命令：%s", m.GroupCode, m.Sender.Uin, cmd)
+			if args := strings.Fields(cmd); len(args) > 0 && args[0] == "clip" {
+				// 剪辑可能需要较长时间，剪辑的文件要上传到发送命令的 QQ 群
+				go func() {
+					miraiSendQQGroup(m.GroupCode, miraiClip(message.Source{SourceType: message.SourceGroup, PrimaryID: m.GroupCode}, args[1:]), nil)
+				}()
+				return
+			}
 			if s := handleAllCmd(cmd); s != "" {
-				miraiSendQQGroup(m.GroupCode, s)
+				miraiSendQQGroup(m.GroupCode, s, nil)
 			} else {
-				miraiSendQQGroup(m.GroupCode, handleErrMsg)
+				miraiSendQQGroup(m.GroupCode, handleErrMsg, nil)
 			}
 		}
 	}
 }
 
-// 发送消息到指定的 QQ
-func miraiSendQQ(qq int64, text string) {
+// 新建消息，image 不为空时上传图片并附在文字后面，上传失败时只发送文字
+func newMiraiMessage(target message.Source, text string, image []byte) *message.SendingMessage {
+	msg := message.NewSendingMessage()
+	msg.Append(message.NewText(text))
+	if len(image) != 0 && miraiClient != nil {
+		if elem, err := miraiClient.UploadImage(target, bytes.NewReader(image)); err == nil {
+			msg.Append(elem)
+		} else {
+			lPrintErrf("上传图片失败，只发送文字：%v", err)
+		}
+	}
+	return msg
+}
+
+// 发送消息到指定的 QQ，image 不为空时附上图片
+func miraiSendQQ(qq int64, text string, image []byte) bool {
 	if qq <= 0 {
 		lPrintErrf("QQ 号 %d 小于等于 0，取消发送消息", qq)
-		return
+		return false
 	}
-	msg := message.NewSendingMessage()
-	msg.Append(message.NewText(text))
 	if miraiClient != nil {
+		msg := newMiraiMessage(message.Source{SourceType: message.SourcePrivate, PrimaryID: qq}, text, image)
 		lPrintln("给 QQ", qq, "发送消息")
 		if result := miraiClient.SendPrivateMessage(qq, msg); result == nil || result.Id <= 0 {
 			lPrintErr("给 QQ", qq, "发送消息失败")
+			return false
 		}
-	} else {
-		lPrintErr("miraiClient 不能为 nil")
+		return true
 	}
+	lPrintErr("miraiClient 不能为 nil")
+	return false
 }
 
-// 发送消息到指定的 QQ 群
-func miraiSendQQGroup(qqGroup int64, text string) {
+// 发送消息到指定的 QQ 群，image 不为空时附上图片
+func miraiSendQQGroup(qqGroup int64, text string, image []byte) bool {
 	if qqGroup <= 0 {
 		lPrintErrf("QQ 群号 %d 小于等于 0，取消发送消息", qqGroup)
-		return
+		return false
 	}
-	msg := message.NewSendingMessage()
-	msg.Append(message.NewText(text))
 	if miraiClient != nil {
+		msg := newMiraiMessage(message.Source{SourceType: message.SourceGroup, PrimaryID: qqGroup}, text, image)
 		lPrintln("给 QQ 群", qqGroup, "发送消息")
 		if result := miraiClient.SendGroupMessage(qqGroup, msg); result == nil || result.Id <= 0 {
 			lPrintErr("给 QQ 群", qqGroup, "发送消息失败")
+			return false
 		}
-	} else {
-		lPrintErr("miraiClient 不能为 nil")
+		return true
 	}
+	lPrintErr("miraiClient 不能为 nil")
+	return false
 }
 
-// 发送消息到指定的 QQ 群，并@全体成员
-func miraiSendQQGroupAtAll(qqGroup int64, text string) {
+// 发送消息到指定的 QQ 群，并@全体成员，image 不为空时附上图片
+func miraiSendQQGroupAtAll(qqGroup int64, text string, image []byte) bool {
 	if qqGroup <= 0 {
 		lPrintErrf("QQ 群号 %d 小于等于 0，取消发送消息", qqGroup)
-		return
+		return false
 	}
-	msg := message.NewSendingMessage()
-	msg.Append(message.AtAll())
-	msg.Append(message.NewText(text))
 	if miraiClient != nil {
+		msg := newMiraiMessage(message.Source{SourceType: message.SourceGroup, PrimaryID: qqGroup}, text, image)
+		msg.Elements = append([]message.IMessageElement{message.AtAll()}, msg.Elements...)
 		lPrintln("给 QQ 群", qqGroup, "发送消息")
 		if result := miraiClient.SendGroupMessage(qqGroup, msg); result == nil || result.Id <= 0 {
 			lPrintErr("给 QQ 群", qqGroup, "发送@全体成员的消息失败，尝试发送普通群消息")
-			miraiSendQQGroup(qqGroup, text)
+			return miraiSendQQGroup(qqGroup, text, image)
 		}
-	} else {
-		lPrintErr("miraiClient 不能为 nil")
+		return true
 	}
+	lPrintErr("miraiClient 不能为 nil")
+	return false
 }
 
 // 发送消息
 func (s *streamer) sendMirai(text string, isSendGroup bool) {
+	s.sendMiraiImage(text, nil, isSendGroup)
+}
+
+// 发送附上图片的消息到指定的 QQ 和 QQ 群，image 为空时只发送文字
+func (s *streamer) sendMiraiImage(text string, image []byte, isSendGroup bool) {
 	defer func() {
 		if err := recover(); err != nil {
-			lPrintErr("Recovering from panic in sendMirai(), the error is:", err)
+			lPrintErr("Recovering from panic in sendMiraiImage(), the error is:", err)
 			lPrintErrf("发送%s的消息（%s）到指定的QQ/QQ群时发生错误，取消发送", s.longID(), text)
 		}
 	}()
@@ -540,7 +575,8 @@ func (s *streamer) sendMirai(text string, isSendGroup bool) {
 					lPrintErrf("QQ 号 %d 不是 QQ 机器人的好友，取消发送消息", qq)
 					continue
 				}
-				miraiSendQQ(qq, text)
+				ok := miraiSendQQ(qq, text, image)
+				s.addNotifyHistory(notifyTarget("qq", qq), text, ok)
 			} else {
 				lPrintErrf("QQ 号 %d 小于等于 0，取消发送消息", qq)
 			}
@@ -558,11 +594,13 @@ func (s *streamer) sendMirai(text string, isSendGroup bool) {
 						continue
 					} else {
 						info := groupInfo.FindMember(config.Mirai.BotQQ)
+						var ok bool
 						if info.Permission == client.Member {
-							miraiSendQQGroup(qqGroup, text)
+							ok = miraiSendQQGroup(qqGroup, text, image)
 						} else {
-							miraiSendQQGroupAtAll(qqGroup, text)
+							ok = miraiSendQQGroupAtAll(qqGroup, text, image)
 						}
+						s.addNotifyHistory(notifyTarget("qqgroup", qqGroup), text, ok)
 					}
 				} else {
 					lPrintErrf("QQ 群号 %d 小于等于 0，取消发送消息", qqGroup)
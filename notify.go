@@ -2,6 +2,8 @@
 package main
 
 import (
+	"fmt"
+
 	"github.com/gen2brain/beeep"
 )
 
@@ -22,3 +24,15 @@ type notify struct {
 func desktopNotify(text string) {
 	beeep.Alert("AcFun 直播通知", text, logoFileLocation)
 }
+
+// 开播提醒，发送到 QQ 和 QQ 群的消息会附上直播间封面
+func (s *streamer) notifyLiveOn(title string) {
+	desktopNotify(s.Name + "正在直播：" + title)
+	var cover []byte
+	if *isMirai && miraiClient != nil {
+		if img := getLiveImage(s.UID); img != nil {
+			cover = img.cover
+		}
+	}
+	s.sendMiraiImage(fmt.Sprintf("%s正在直播：%s，观看地址：%s", s.Name, title, s.getURL()), cover, true)
+}
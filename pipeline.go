@@ -0,0 +1,773 @@
+// 录播后期处理相关
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 后期处理步骤的类型
+const (
+	stepRemux     = "remux"     // 无损转换格式
+	stepAudio     = "audio"     // 提取音频
+	stepTranscode = "transcode" // 按照 config.json 里的 profiles 转码
+	stepBurnDanmu = "burndanmu" // 将弹幕压制进视频
+	stepChecksum  = "checksum"  // 计算校验和
+	stepMove      = "move"      // 移动文件
+	stepCopy      = "copy"      // 复制文件
+	stepCommand   = "command"   // 运行自定义命令
+)
+
+// 步骤失败时的处理方式
+const (
+	failureAbort    = "abort"    // 停止处理，之后的步骤都不会运行
+	failureContinue = "continue" // 忽略错误，继续运行之后的步骤
+)
+
+// 任务和步骤的状态
+const (
+	statusQueued  = "queued"
+	statusRunning = "running"
+	statusDone    = "done"
+	statusFailed  = "failed"
+	statusSkipped = "skipped"
+)
+
+// 保留的已结束任务的数量
+const maxFinishedJobs = 100
+
+// 不需要运行的步骤返回的错误
+var errSkipStep = errors.New("不需要运行这个步骤")
+
+// 启动时是否没有找到 FFmpeg，没有时后期处理会跳过需要 FFmpeg 的步骤，分段也不会合并
+var ffmpegMissing bool
+
+// burndanmu 步骤的设置
+type burnDanmuConfig struct {
+	Workers int      `json:"workers"` // 同时运行的有 burndanmu 步骤的任务的数量，压制弹幕需要大量 CPU
+	Args    []string `json:"args"`    // 没有设置 profile 时使用的 FFmpeg 输出参数
+}
+
+// 同时运行的有 burndanmu 步骤的任务的数量上限，每次都读取现在的设置
+func burnWorkers() int {
+	if config.BurnDanmu.Workers <= 0 {
+		return 1
+	}
+	return config.BurnDanmu.Workers
+}
+
+// 后期处理的步骤
+type pipelineStep struct {
+	Type      string   `json:"type"`      // 步骤类型，有 remux、audio、transcode、burndanmu、checksum、move、copy 和 command
+	Format    string   `json:"format"`    // remux、transcode 和 burndanmu 输出的视频格式，为空时使用 config.json 里的 output，audio 输出的音频格式，为空时使用 live.json 里的 audioFormat
+	Profile   string   `json:"profile"`   // transcode 和 burndanmu 使用的 config.json 里的 profiles 的名字
+	Keep      bool     `json:"keep"`      // transcode 和 burndanmu 成功后是否保留原视频
+	Algorithm string   `json:"algorithm"` // checksum 使用的算法，有 md5、sha1 和 sha256，默认为 sha256
+	Dest      []string `json:"dest"`      // move 和 copy 的目标文件夹，move 为空时使用 directory
+	Command   []string `json:"command"`   // command 运行的命令和参数
+	Retry     int      `json:"retry"`     // 失败时的重试次数
+	OnFailure string   `json:"onFailure"` // 重试后依然失败时的处理方式，有 abort 和 continue，默认为 abort
+}
+
+// 没有设置 pipeline 时使用的默认步骤，和以前一样转换格式后移动文件
+var defaultPipeline = []pipelineStep{
+	{Type: stepRemux, OnFailure: failureContinue},
+	{Type: stepMove},
+}
+
+// 只下载音频并且没有设置 pipeline 时使用的默认步骤，提取音频后移动文件
+var defaultAudioPipeline = []pipelineStep{
+	{Type: stepAudio, OnFailure: failureContinue},
+	{Type: stepMove},
+}
+
+// 步骤的运行状态
+type stepStatus struct {
+	Type   string `json:"type"`   // 步骤类型
+	Status string `json:"status"` // 状态，有 queued、running、done、failed 和 skipped
+	Error  string `json:"error"`  // 失败时的错误
+	Log    string `json:"log"`    // 运行日志
+	Start  int64  `json:"start"`  // 开始时间，是以毫秒为单位的 Unix 时间
+	End    int64  `json:"end"`    // 结束时间，是以毫秒为单位的 Unix 时间
+
+	Progress *ffmpegProgress `json:"progress,omitempty"` // burndanmu 的 FFmpeg 进度
+}
+
+// 后期处理任务
+type job struct {
+	ID      int          `json:"id"`      // 任务 ID
+	UID     int          `json:"uid"`     // 主播 uid
+	Name    string       `json:"name"`    // 主播名字
+	LiveID  string       `json:"liveID"`  // 直播 ID
+	Video   string       `json:"video"`   // 录播文件，会随着处理步骤改变
+	Danmu   string       `json:"danmu"`   // 弹幕文件，会随着处理步骤改变
+	Meta    string       `json:"meta"`    // 元数据文件，会随着处理步骤改变
+	Files   []string     `json:"files"`   // 处理步骤生成的其他文件以及跟着录播的直播间封面和头像
+	Status  string       `json:"status"`  // 任务状态
+	Steps   []stepStatus `json:"steps"`   // 各步骤的状态
+	Created int64        `json:"created"` // 创建时间，是以毫秒为单位的 Unix 时间
+
+	s        streamer       // 主播设置
+	pipeline []pipelineStep // 处理步骤
+	source   string         // 下载时的录播文件，用于删除 recording.json 里的记录
+	waiting  bool           // 是否已经提醒过在等待压制弹幕的名额
+}
+
+// 后期处理任务队列
+var jobs struct {
+	sync.Mutex
+	once    sync.Once
+	cond    *sync.Cond
+	nextID  int
+	queue   []*job // 等待处理的任务
+	list    []*job // 所有任务，包括已结束的任务
+	burning int    // 正在运行的有 burndanmu 步骤的任务的数量
+}
+
+// 启动时检查 FFmpeg，没有时只提醒一次，之后跳过需要 FFmpeg 的步骤
+func checkFFmpeg() {
+	if getFFmpeg() == "" {
+		ffmpegMissing = true
+		lPrintWarn("没有找到 FFmpeg，录播后期处理会跳过 remux、audio、transcode 和 burndanmu 步骤，也不会合并录播分段")
+	}
+}
+
+// 步骤是否需要 FFmpeg
+func (step *pipelineStep) needFFmpeg() bool {
+	switch step.Type {
+	case stepRemux, stepAudio, stepTranscode, stepBurnDanmu:
+		return true
+	}
+	return false
+}
+
+// 主播的后期处理步骤
+func (s *streamer) getPipeline() []pipelineStep {
+	if len(s.Pipeline) != 0 {
+		if err := checkPipeline(s.Pipeline, s.AudioOnly); err != nil {
+			lPrintErrf("%s里%s的 pipeline 设置错误，使用默认设置：%v", liveFile, s.longID(), err)
+		} else {
+			return s.Pipeline
+		}
+	}
+	if len(config.Pipeline) != 0 {
+		// config.json 里的 pipeline 可能不适用于只下载音频
+		if err := checkPipeline(config.Pipeline, s.AudioOnly); err != nil {
+			lPrintErrf("%s里的 pipeline 不适用于只下载音频的%s，使用默认设置：%v", configFile, s.longID(), err)
+		} else {
+			return config.Pipeline
+		}
+	}
+	if s.AudioOnly {
+		return defaultAudioPipeline
+	}
+	return defaultPipeline
+}
+
+// 主播的录播和弹幕的保存文件夹
+func (s *streamer) directory() string {
+	if s.Directory != "" {
+		return s.Directory
+	}
+	return config.Directory
+}
+
+// 检查后期处理步骤是否正确，audioOnly 为只下载音频时的检查，只下载音频时不能使用 remux 和 burndanmu，提取音频后不能再处理视频
+func checkPipeline(pipeline []pipelineStep, audioOnly bool) error {
+	// 之前的步骤是否已经提取音频
+	extracted := false
+	for i, step := range pipeline {
+		switch {
+		case audioOnly && (step.Type == stepRemux || step.Type == stepBurnDanmu):
+			return fmt.Errorf("只下载音频时第%d个步骤不能使用 %s，提取音频请使用 %s", i+1, step.Type, stepAudio)
+		case extracted && (step.Type == stepRemux || step.Type == stepAudio || step.Type == stepBurnDanmu):
+			return fmt.Errorf("提取音频后第%d个步骤不能使用 %s", i+1, step.Type)
+		}
+		switch step.Type {
+		case stepAudio:
+			extracted = true
+		case stepRemux, stepChecksum, stepMove, stepCopy:
+		case stepTranscode, stepBurnDanmu:
+			if _, ok := config.Profiles[step.Profile]; step.Profile != "" && !ok {
+				return fmt.Errorf("第%d个步骤的 profile %s 不存在", i+1, step.Profile)
+			}
+			if step.Type == stepTranscode && step.Profile == "" {
+				return fmt.Errorf("第%d个步骤 transcode 需要设置 profile", i+1)
+			}
+		case stepCommand:
+			if len(step.Command) == 0 {
+				return fmt.Errorf("第%d个步骤 command 需要设置 command", i+1)
+			}
+		default:
+			return fmt.Errorf("第%d个步骤的类型 %s 不正确", i+1, step.Type)
+		}
+		switch step.Algorithm {
+		case "", "md5", "sha1", "sha256":
+		default:
+			return fmt.Errorf("第%d个步骤的 algorithm %s 不正确", i+1, step.Algorithm)
+		}
+		switch step.OnFailure {
+		case "", failureAbort, failureContinue:
+		default:
+			return fmt.Errorf("第%d个步骤的 onFailure %s 不正确", i+1, step.OnFailure)
+		}
+	}
+	return nil
+}
+
+// 下载结束后的处理，recordFile 或 assFile 可以为空
+func (s *streamer) postRecord(liveID, recordFile, assFile string) {
+	source := recordFile
+	// 没有下载到数据的录播不需要处理，只处理弹幕
+	if recordFile != "" {
+		if info, err := os.Stat(recordFile); err != nil || info.Size() == 0 {
+			lPrintWarnf("录播文件 %s 不存在或为空，不对它进行后期处理", recordFile)
+			if assFile == "" {
+				discardPart(recordFile, "")
+				return
+			}
+			_ = os.Remove(recordFile)
+			recordFile = ""
+		}
+	}
+	if recordFile == "" && assFile == "" {
+		return
+	}
+	pipeline := s.getPipeline()
+	j := &job{
+		UID:      s.UID,
+		Name:     s.Name,
+		LiveID:   liveID,
+		Video:    recordFile,
+		Danmu:    assFile,
+		Status:   statusQueued,
+		Steps:    make([]stepStatus, len(pipeline)),
+		Created:  time.Now().UnixMilli(),
+		s:        *s,
+		pipeline: pipeline,
+		source:   source,
+	}
+	for i, step := range pipeline {
+		j.Steps[i] = stepStatus{Type: step.Type, Status: statusQueued}
+	}
+	file := recordFile
+	if file == "" {
+		file = assFile
+	}
+	if meta := sidecarFile(file); isFileExist(meta) {
+		j.Meta = meta
+		// 直播间封面和头像跟着录播一起移动和复制
+		if sc, err := readSidecar(meta); err == nil {
+			for _, name := range []string{sc.Cover, sc.Avatar} {
+				if image := filepath.Join(filepath.Dir(meta), name); name != "" && isFileExist(image) {
+					j.Files = append(j.Files, image)
+				}
+			}
+		}
+	}
+
+	jobs.Lock()
+	jobs.nextID++
+	j.ID = jobs.nextID
+	jobs.list = append(jobs.list, j)
+	jobs.Unlock()
+
+	if !*isListen {
+		// 程序只在单独下载一个直播视频，直接处理，防止程序提前结束运行
+		j.run()
+		return
+	}
+	jobs.once.Do(startJobWorkers)
+	jobs.Lock()
+	jobs.queue = append(jobs.queue, j)
+	jobs.Unlock()
+	jobs.cond.Signal()
+	lPrintf("%s的录播后期处理任务#%d已加入队列", s.longID(), j.ID)
+}
+
+// 启动处理任务的 goroutine
+func startJobWorkers() {
+	jobs.cond = sync.NewCond(&jobs)
+	workers := config.JobWorkers
+	if workers <= 0 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		go func() {
+			for {
+				jobs.Lock()
+				j := takeJob()
+				for j == nil {
+					jobs.cond.Wait()
+					j = takeJob()
+				}
+				burn := j.needBurnSlot()
+				if burn {
+					jobs.burning++
+				}
+				jobs.Unlock()
+				j.run()
+				if burn {
+					jobs.Lock()
+					jobs.burning--
+					jobs.Unlock()
+					// 等待压制弹幕名额的任务可能可以运行了
+					jobs.cond.Broadcast()
+				}
+			}
+		}()
+	}
+}
+
+// 从队列里取出第一个可以运行的任务，有 burndanmu 步骤的任务要等到有压制弹幕的名额时才会运行，
+// 不会占用处理其他任务的 goroutine，没有可以运行的任务时返回 nil，需要锁住 jobs
+func takeJob() *job {
+	for i, j := range jobs.queue {
+		if j.needBurnSlot() && jobs.burning >= burnWorkers() {
+			if !j.waiting {
+				j.waiting = true
+				lPrintf("同时压制弹幕的任务数量达到上限，任务#%d等待其他任务压制结束", j.ID)
+			}
+			continue
+		}
+		jobs.queue = append(jobs.queue[:i], jobs.queue[i+1:]...)
+		return j
+	}
+	return nil
+}
+
+// 任务是否有需要运行的 burndanmu 步骤
+func (j *job) needBurnSlot() bool {
+	if ffmpegMissing || j.Danmu == "" {
+		return false
+	}
+	for _, step := range j.pipeline {
+		if step.Type == stepBurnDanmu {
+			return true
+		}
+	}
+	return false
+}
+
+// 删除过多的已结束任务，需要锁住 jobs
+func pruneJobs() {
+	finished := 0
+	for _, j := range jobs.list {
+		if j.Status == statusDone || j.Status == statusFailed {
+			finished++
+		}
+	}
+	list := jobs.list[:0]
+	for _, j := range jobs.list {
+		if finished > maxFinishedJobs && (j.Status == statusDone || j.Status == statusFailed) {
+			finished--
+			continue
+		}
+		list = append(list, j)
+	}
+	jobs.list = list
+}
+
+// 修改任务，f 运行时会锁住 jobs
+func (j *job) update(f func()) {
+	jobs.Lock()
+	defer jobs.Unlock()
+	f()
+}
+
+// 运行任务的所有步骤
+func (j *job) run() {
+	defer func() {
+		if err := recover(); err != nil {
+			lPrintErr("Recovering from panic in run(), the error is:", err)
+			lPrintErrf("运行%s的录播后期处理任务#%d时发生错误", j.s.longID(), j.ID)
+			j.update(func() { j.Status = statusFailed })
+		}
+		if j.source != "" {
+			removeJournal(j.source)
+		}
+
+		jobs.Lock()
+		pruneJobs()
+		jobs.Unlock()
+	}()
+
+	j.update(func() { j.Status = statusRunning })
+	lPrintf("开始运行%s的录播后期处理任务#%d", j.s.longID(), j.ID)
+	status := statusDone
+	for i, step := range j.pipeline {
+		if status == statusFailed {
+			j.update(func() { j.Steps[i].Status = statusSkipped })
+			continue
+		}
+		j.update(func() {
+			j.Steps[i].Status = statusRunning
+			j.Steps[i].Start = time.Now().UnixMilli()
+		})
+		var log string
+		var err error
+		for try := 0; try <= step.Retry; try++ {
+			if try > 0 {
+				lPrintWarnf("任务#%d的第%d个步骤 %s 失败，开始第%d次重试", j.ID, i+1, step.Type, try)
+			}
+			if log, err = j.runStep(i, step); err == nil || err == errSkipStep {
+				break
+			}
+		}
+		st := statusDone
+		if err == errSkipStep {
+			st, err = statusSkipped, nil
+		} else if err != nil {
+			lPrintErrf("任务#%d的第%d个步骤 %s 失败：%v", j.ID, i+1, step.Type, err)
+			st = statusFailed
+			if step.OnFailure != failureContinue {
+				status = statusFailed
+			}
+		}
+		j.update(func() {
+			j.Steps[i].Status = st
+			j.Steps[i].Log = log
+			if err != nil {
+				j.Steps[i].Error = err.Error()
+			}
+			j.Steps[i].End = time.Now().UnixMilli()
+		})
+	}
+	j.update(func() { j.Status = status })
+	j.addHistory()
+	if status == statusDone {
+		lPrintf("%s的录播后期处理任务#%d已完成", j.s.longID(), j.ID)
+	} else {
+		lPrintErrf("%s的录播后期处理任务#%d失败，之后的步骤已跳过", j.s.longID(), j.ID)
+	}
+}
+
+// 添加处理后的录播和弹幕文件的历史记录
+func (j *job) addHistory() {
+	jobs.Lock()
+	e := historyEntry{
+		Type:   historyRecord,
+		UID:    j.UID,
+		Name:   j.Name,
+		LiveID: j.LiveID,
+		Video:  j.Video,
+		Danmu:  j.Danmu,
+	}
+	meta := j.Meta
+	jobs.Unlock()
+	if e.Video == "" {
+		e.Type = historyDanmu
+	}
+	if meta != "" {
+		if sc, err := readSidecar(meta); err == nil {
+			e.Title, e.Start, e.End = sc.Title, sc.Start, sc.End
+		}
+	}
+	addHistory(e)
+}
+
+// 更新元数据里处理后的录播文件名
+func (j *job) updateMeta() {
+	jobs.Lock()
+	video, meta := j.Video, j.Meta
+	jobs.Unlock()
+	if meta != "" && video != "" {
+		updateSidecar(meta, func(sc *sidecar) {
+			sc.Video = filepath.Base(video)
+		})
+	}
+}
+
+// 运行第 i 个步骤，返回运行日志
+func (j *job) runStep(i int, step pipelineStep) (log string, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("%v", e)
+		}
+	}()
+
+	jobs.Lock()
+	video, danmu, meta, files := j.Video, j.Danmu, j.Meta, append([]string(nil), j.Files...)
+	jobs.Unlock()
+	format := step.Format
+	if format == "" {
+		format = config.Output
+	}
+	if ffmpegMissing && step.needFFmpeg() {
+		return "没有找到 FFmpeg，跳过这个步骤", errSkipStep
+	}
+
+	switch step.Type {
+	case stepRemux:
+		if video == "" {
+			return "", errSkipStep
+		}
+		out, err := remuxFile(video, format)
+		if err != nil {
+			return "", err
+		}
+		if out == video {
+			return "", errSkipStep
+		}
+		j.update(func() { j.Video = out })
+		j.updateMeta()
+		return "转换为 " + out, nil
+	case stepAudio:
+		if video == "" {
+			return "", errSkipStep
+		}
+		audioFormat := step.Format
+		if audioFormat == "" {
+			audioFormat = j.s.AudioFormat
+		}
+		out, err := extractAudio(video, audioFormat)
+		if err != nil {
+			return "", err
+		}
+		j.update(func() { j.Video = out })
+		j.updateMeta()
+		return "提取音频 " + out, nil
+	case stepTranscode, stepBurnDanmu:
+		if video == "" || (step.Type == stepBurnDanmu && danmu == "") {
+			return "", errSkipStep
+		}
+		suffix := step.Profile
+		var args []string
+		if step.Type == stepBurnDanmu {
+			suffix = "danmu"
+			args = append(args, "-vf", "ass=filename="+escapeFilterPath(danmu))
+		}
+		switch {
+		case step.Profile != "":
+			args = append(args, config.Profiles[step.Profile]...)
+		case step.Type == stepBurnDanmu:
+			args = append(args, config.BurnDanmu.Args...)
+		default:
+			args = append(args, "-c:a", "copy")
+		}
+		out := strings.TrimSuffix(video, filepath.Ext(video)) + "_" + suffix + "." + format
+		args = append([]string{"-i", video}, append(args, out)...)
+		var err error
+		if step.Type == stepBurnDanmu {
+			err = j.burnDanmu(i, args)
+		} else {
+			err = runFFmpeg(args...)
+		}
+		if err != nil {
+			_ = os.Remove(out)
+			return "", err
+		}
+		if step.Keep {
+			j.update(func() { j.Files = append(j.Files, video) })
+		} else if err := os.Remove(video); err != nil {
+			lPrintErrf("删除文件 %s 失败：%v", video, err)
+		}
+		j.update(func() { j.Video = out })
+		j.updateMeta()
+		return "输出为 " + out, nil
+	case stepChecksum:
+		var lines []string
+		for _, file := range []string{video, danmu} {
+			if file == "" {
+				continue
+			}
+			sumFile, sum, err := writeChecksum(file, step.Algorithm)
+			if err != nil {
+				return "", err
+			}
+			lines = append(lines, sum+"  "+filepath.Base(file))
+			j.update(func() { j.Files = append(j.Files, sumFile) })
+		}
+		return strings.Join(lines, "\n"), nil
+	case stepMove, stepCopy:
+		dests := step.Dest
+		if len(dests) == 0 {
+			if step.Type == stepCopy {
+				return "", errSkipStep
+			}
+			if dests = []string{j.s.directory()}; dests[0] == "" {
+				return "", errSkipStep
+			}
+		}
+		var lines []string
+		// 不存在的文件，其他文件依然会移动或复制
+		var missing []string
+		move := func(file string) (string, error) {
+			if file == "" {
+				return "", nil
+			}
+			if !isFileExist(file) {
+				missing = append(missing, file)
+				return file, nil
+			}
+			newFile := file
+			for i, dest := range dests {
+				var err error
+				op := stepCopy
+				if step.Type == stepMove && i == len(dests)-1 {
+					op = stepMove
+					newFile, err = moveFileTo(file, dest)
+				} else {
+					_, err = copyFileTo(file, dest)
+				}
+				if err != nil {
+					return file, err
+				}
+				lines = append(lines, op+" "+file+" -> "+dest)
+			}
+			return newFile, nil
+		}
+		if video, err = move(video); err != nil {
+			return "", err
+		}
+		if danmu, err = move(danmu); err != nil {
+			return "", err
+		}
+		if meta, err = move(meta); err != nil {
+			return "", err
+		}
+		for i, file := range files {
+			if files[i], err = move(file); err != nil {
+				return "", err
+			}
+		}
+		j.update(func() {
+			j.Video, j.Danmu, j.Meta, j.Files = video, danmu, meta, files
+		})
+		if len(missing) > 0 {
+			return strings.Join(lines, "\n"), fmt.Errorf("文件不存在：%s", strings.Join(missing, "、"))
+		}
+		return strings.Join(lines, "\n"), nil
+	case stepCommand:
+		r := strings.NewReplacer(
+			"{video}", video,
+			"{danmu}", danmu,
+			"{meta}", meta,
+			"{uid}", strconv.Itoa(j.UID),
+			"{name}", j.Name,
+			"{liveID}", j.LiveID,
+		)
+		args := make([]string, len(step.Command))
+		for i, arg := range step.Command {
+			args[i] = r.Replace(arg)
+		}
+		cmd := exec.Command(args[0], args[1:]...)
+		hideCmdWindow(cmd)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			return lastLines(out, 10), fmt.Errorf("运行命令 %s 失败：%w", args[0], err)
+		}
+		return lastLines(out, 10), nil
+	default:
+		return "", fmt.Errorf("步骤类型 %s 不正确", step.Type)
+	}
+}
+
+// 压制弹幕，同时运行的数量受 config.json 里的 burnDanmu.workers 限制，进度记录在第 i 个步骤的状态里
+func (j *job) burnDanmu(i int, args []string) error {
+	lPrintf("开始压制%s的录播任务#%d的弹幕", j.s.longID(), j.ID)
+	progress := new(ffmpegProgress)
+	j.update(func() { j.Steps[i].Progress = progress })
+	return runFFmpegProgress(progress, args...)
+}
+
+// 运行 FFmpeg 并将进度写入 progress，失败时返回的错误包含 FFmpeg 输出的最后几行
+func runFFmpegProgress(progress *ffmpegProgress, args ...string) error {
+	ffmpegFile := getFFmpeg()
+	if ffmpegFile == "" {
+		return errors.New("没有找到 FFmpeg")
+	}
+	cmd := exec.Command(ffmpegFile, append([]string{"-y", "-hide_banner", "-nostats", "-progress", "pipe:1"}, args...)...)
+	hideCmdWindow(cmd)
+	stderr := &ffmpegStderr{progress: progress}
+	cmd.Stdout = progress
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%w，FFmpeg 的输出为：%s", err, lastLines(stderr.tail, 5))
+	}
+	return nil
+}
+
+// 运行 FFmpeg，失败时返回的错误包含 FFmpeg 输出的最后几行
+func runFFmpeg(args ...string) error {
+	ffmpegFile := getFFmpeg()
+	if ffmpegFile == "" {
+		return errors.New("没有找到 FFmpeg")
+	}
+	cmd := exec.Command(ffmpegFile, append([]string{"-y", "-hide_banner"}, args...)...)
+	hideCmdWindow(cmd)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%w，FFmpeg 的输出为：%s", err, lastLines(out, 5))
+	}
+	return nil
+}
+
+// 转义 FFmpeg 滤镜里的文件路径
+func escapeFilterPath(path string) string {
+	path = filepath.ToSlash(path)
+	// 滤镜参数的转义
+	path = strings.NewReplacer(`\`, `\\`, `:`, `\:`, `'`, `\'`).Replace(path)
+	// 滤镜图的转义
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`, `[`, `\[`, `]`, `\]`, `,`, `\,`, `;`, `\;`).Replace(path)
+}
+
+// 计算文件的校验和并写入 文件名.算法 的文件里，返回校验和文件和校验和
+func writeChecksum(file, algorithm string) (sumFile, sum string, err error) {
+	if algorithm == "" {
+		algorithm = "sha256"
+	}
+	var h hash.Hash
+	switch algorithm {
+	case "md5":
+		h = md5.New()
+	case "sha1":
+		h = sha1.New()
+	default:
+		h = sha256.New()
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return "", "", err
+	}
+	defer f.Close()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", "", err
+	}
+	sum = hex.EncodeToString(h.Sum(nil))
+	sumFile = file + "." + algorithm
+	// 和 sha256sum 等命令的输出格式一样
+	err = os.WriteFile(sumFile, []byte(sum+"  "+filepath.Base(file)+"\n"), 0644)
+	return sumFile, sum, err
+}
+
+// 列出后期处理任务
+func listJob() []job {
+	jobs.Lock()
+	defer jobs.Unlock()
+	list := make([]job, 0, len(jobs.list))
+	for _, j := range jobs.list {
+		c := *j
+		c.Files = append([]string(nil), j.Files...)
+		c.Steps = append([]stepStatus(nil), j.Steps...)
+		for i := range c.Steps {
+			if c.Steps[i].Progress != nil {
+				c.Steps[i].Progress = c.Steps[i].Progress.copy()
+			}
+		}
+		list = append(list, c)
+	}
+	return list
+}
@@ -0,0 +1,136 @@
+// FFmpeg 下载进度相关
+package main
+
+import (
+	"bytes"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FFmpeg 通过 -progress 输出的下载进度，实现了 io.Writer 接口
+type ffmpegProgress struct {
+	sync.Mutex
+	Elapsed    float64 `json:"elapsed"`           // 已下载的直播视频时长，单位为秒
+	Bytes      int64   `json:"bytes"`             // 已写入的字节数
+	Bitrate    float64 `json:"bitrate"`           // 当前码率，单位为 kbit/s
+	Speed      float64 `json:"speed"`             // 下载速度相对于播放速度的倍数，正常时应该接近 1
+	DropFrames int64   `json:"dropFrames"`        // 丢弃的帧数
+	DupFrames  int64   `json:"dupFrames"`         // 重复的帧数
+	LastUpdate int64   `json:"lastUpdate"`        // 最后更新进度的时间，是以毫秒为单位的 Unix 时间
+	Total      float64 `json:"total,omitempty"`   // 输入文件的总时长，单位为秒，只有处理文件时才有
+	Percent    float64 `json:"percent,omitempty"` // 处理文件的进度百分比，只有处理文件时才有
+	buf        []byte  // 还没有处理的不完整的行
+}
+
+// 返回下载进度的副本
+func (p *ffmpegProgress) copy() *ffmpegProgress {
+	p.Lock()
+	defer p.Unlock()
+	return &ffmpegProgress{
+		Elapsed:    p.Elapsed,
+		Bytes:      p.Bytes,
+		Bitrate:    p.Bitrate,
+		Speed:      p.Speed,
+		DropFrames: p.DropFrames,
+		DupFrames:  p.DupFrames,
+		LastUpdate: p.LastUpdate,
+		Total:      p.Total,
+		Percent:    p.Percent,
+	}
+}
+
+// 解析 FFmpeg 的输出，每一行的格式为 key=value
+func (p *ffmpegProgress) Write(b []byte) (int, error) {
+	p.Lock()
+	defer p.Unlock()
+	p.buf = append(p.buf, b...)
+	for {
+		i := bytes.IndexByte(p.buf, '\n')
+		if i < 0 {
+			break
+		}
+		line := strings.TrimSpace(string(p.buf[:i]))
+		p.buf = p.buf[i+1:]
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		p.parse(key, strings.TrimSpace(value))
+	}
+	return len(b), nil
+}
+
+// 解析一个进度值，值为 N/A 时不更新，需要锁住 p
+func (p *ffmpegProgress) parse(key, value string) {
+	switch key {
+	case "out_time_us", "out_time_ms":
+		// 旧版 FFmpeg 的 out_time_ms 的单位实际上也是微秒
+		if us, err := strconv.ParseInt(value, 10, 64); err == nil && us >= 0 {
+			p.Elapsed = float64(us) / 1e6
+		}
+	case "total_size":
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+			p.Bytes = n
+		}
+	case "bitrate":
+		if f, err := strconv.ParseFloat(strings.TrimSuffix(value, "kbits/s"), 64); err == nil {
+			p.Bitrate = f
+		}
+	case "speed":
+		if f, err := strconv.ParseFloat(strings.TrimSuffix(value, "x"), 64); err == nil {
+			p.Speed = f
+		}
+	case "drop_frames":
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+			p.DropFrames = n
+		}
+	case "dup_frames":
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+			p.DupFrames = n
+		}
+	case "progress":
+		// 每一组进度以 progress=continue 或 progress=end 结束
+		p.LastUpdate = time.Now().UnixMilli()
+		if p.Total > 0 {
+			p.Percent = math.Min(p.Elapsed/p.Total*100, 100)
+		}
+		if value == "end" && p.Total > 0 {
+			p.Percent = 100
+		}
+	}
+}
+
+// FFmpeg 的 stderr 输出，解析输入文件的总时长，保留最后的输出用于错误信息，实现了 io.Writer 接口
+type ffmpegStderr struct {
+	progress *ffmpegProgress // 设置总时长的下载进度
+	tail     []byte          // 最后的输出
+}
+
+// stderr 最多保留的字节数
+const ffmpegStderrTail = 8 * 1024
+
+func (e *ffmpegStderr) Write(b []byte) (int, error) {
+	e.tail = append(e.tail, b...)
+	if len(e.tail) > ffmpegStderrTail {
+		e.tail = e.tail[len(e.tail)-ffmpegStderrTail:]
+	}
+	// 第一个输入文件的信息里有 Duration: 00:01:02.34, start: ...
+	if e.progress != nil {
+		e.progress.Lock()
+		if e.progress.Total == 0 {
+			if i := bytes.Index(e.tail, []byte("Duration: ")); i >= 0 {
+				rest := e.tail[i+len("Duration: "):]
+				if j := bytes.IndexByte(rest, ','); j > 0 {
+					if d, err := parseASSTime(string(rest[:j])); err == nil {
+						e.progress.Total = d.Seconds()
+					}
+				}
+			}
+		}
+		e.progress.Unlock()
+	}
+	return len(b), nil
+}
@@ -0,0 +1,246 @@
+// 同时下载直播视频的数量限制和排队相关
+package main
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 排队时检查主播是否还在直播的时间间隔
+const queueCheckInterval = 30 * time.Second
+
+// 排队等待下载的直播视频
+type queuedRecord struct {
+	UID      int           `json:"uid"`      // 主播 uid
+	Name     string        `json:"name"`     // 主播名字
+	LiveID   string        `json:"liveID"`   // 直播 ID
+	Priority int           `json:"priority"` // 主播的优先级
+	Since    int64         `json:"since"`    // 开始排队的时间，是以毫秒为单位的 Unix 时间
+	ready    chan struct{} // 轮到下载时会关闭
+	cancel   chan struct{} // 取消排队时会关闭
+}
+
+// 下载直播视频的名额和排队的直播视频
+var recordSlots struct {
+	sync.Mutex
+	active    map[string]int  // 正在下载的直播视频，key 为 liveID，值为主播 uid
+	preempted map[string]bool // 已经被抢占但还没停止下载的直播视频，key 为 liveID
+	queue     []*queuedRecord // 排队等待下载的直播视频
+}
+
+func init() {
+	recordSlots.active = make(map[string]int)
+	recordSlots.preempted = make(map[string]bool)
+}
+
+// 是否还有下载名额，需要锁住 recordSlots
+func hasFreeSlot() bool {
+	return config.MaxRecordings <= 0 || len(recordSlots.active) < config.MaxRecordings
+}
+
+// 将名额分配给优先级最高的排队的直播视频，优先级相同时先排队的优先，需要锁住 recordSlots
+func dispatchSlots() {
+	sort.SliceStable(recordSlots.queue, func(i, j int) bool {
+		return recordSlots.queue[i].Priority > recordSlots.queue[j].Priority
+	})
+	for len(recordSlots.queue) > 0 && hasFreeSlot() {
+		q := recordSlots.queue[0]
+		recordSlots.queue = recordSlots.queue[1:]
+		recordSlots.active[q.LiveID] = q.UID
+		close(q.ready)
+	}
+}
+
+// 从排队中删除，需要锁住 recordSlots
+func removeQueued(q *queuedRecord) bool {
+	for i, r := range recordSlots.queue {
+		if r == q {
+			recordSlots.queue = append(recordSlots.queue[:i], recordSlots.queue[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// 选出要抢占的优先级比 p 低的正在下载的直播视频中优先级最低的一个，需要锁住 recordSlots，
+// 解锁后再调用返回的 stop 停止下载，没有可以抢占的直播视频时返回 nil
+func preemptRecord(p int) (stop func()) {
+	lowest, lowestUID := "", 0
+	for liveID, uid := range recordSlots.active {
+		if recordSlots.preempted[liveID] || priority(uid) >= p {
+			continue
+		}
+		if lowest == "" || priority(uid) < priority(lowestUID) {
+			lowest, lowestUID = liveID, uid
+		}
+	}
+	if lowest == "" {
+		return nil
+	}
+	info, ok := getLiveInfo(lowest)
+	if !ok || !info.isRecording {
+		// 还没开始下载，等下次再抢占
+		return nil
+	}
+	recordSlots.preempted[lowest] = true
+	return func() { stopPreempted(info, lowestUID) }
+}
+
+// 停止下载被抢占的直播视频，改为只下载弹幕，会发送通知，不能锁住 recordSlots
+func stopPreempted(info liveInfo, uid int) {
+	s, ok := getStreamer(uid)
+	if !ok {
+		s = streamer{UID: uid, Name: getName(uid)}
+	}
+	msg := "同时下载的直播视频数量达到上限，优先级更高的主播开播，停止下载" + s.Name + "的直播视频"
+	lPrintWarn(msg)
+	desktopNotify(msg)
+	s.sendMirai(msg, false)
+	stopLiveRecord(info, danmuOnly)
+}
+
+// 获取下载直播视频的名额，名额不足时排队等待，返回 false 时取消下载
+func (s *streamer) acquireRecordSlot(liveID string) (waited, ok bool) {
+	recordSlots.Lock()
+	if _, ok := recordSlots.active[liveID]; ok {
+		recordSlots.Unlock()
+		lPrintWarnf("已经在下载%s的直播视频", s.longID())
+		return false, false
+	}
+	for _, q := range recordSlots.queue {
+		if q.LiveID == liveID {
+			recordSlots.Unlock()
+			lPrintWarnf("%s的直播视频已经在排队等待下载", s.longID())
+			return false, false
+		}
+	}
+	if len(recordSlots.queue) == 0 && hasFreeSlot() {
+		recordSlots.active[liveID] = s.UID
+		recordSlots.Unlock()
+		return false, true
+	}
+
+	q := &queuedRecord{
+		UID:      s.UID,
+		Name:     s.Name,
+		LiveID:   liveID,
+		Priority: s.Priority,
+		Since:    time.Now().UnixMilli(),
+		ready:    make(chan struct{}),
+		cancel:   make(chan struct{}),
+	}
+	recordSlots.queue = append(recordSlots.queue, q)
+	dispatchSlots()
+	select {
+	case <-q.ready:
+		recordSlots.Unlock()
+		return false, true
+	default:
+	}
+	var stop func()
+	if config.Preempt {
+		stop = preemptRecord(s.Priority)
+	}
+	recordSlots.Unlock()
+	if stop != nil {
+		stop()
+	}
+
+	lPrintf("同时下载的直播视频数量达到上限%d，%s的直播视频开始排队等待下载，如要取消排队，运行 stoprecord %d", config.MaxRecordings, s.longID(), s.UID)
+	ctx := mainCtx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ticker := time.NewTicker(queueCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-q.ready:
+			lPrintln("轮到下载" + s.longID() + "的直播视频")
+			return true, true
+		case <-q.cancel:
+			lPrintln("取消排队下载" + s.longID() + "的直播视频")
+			return true, false
+		case <-ctx.Done():
+			s.leaveQueue(q)
+			return true, false
+		case <-ticker.C:
+			if getLiveID(s.UID) != liveID {
+				lPrintln(s.longID() + "已经下播，取消排队下载直播视频")
+				s.leaveQueue(q)
+				return true, false
+			}
+			if config.Preempt {
+				// 被抢占的直播视频停止下载后可能被其他排队的直播视频占用了名额，再次尝试抢占
+				var stop func()
+				recordSlots.Lock()
+				if len(recordSlots.preempted) == 0 {
+					stop = preemptRecord(s.Priority)
+				}
+				recordSlots.Unlock()
+				if stop != nil {
+					stop()
+				}
+			}
+		}
+	}
+}
+
+// 离开排队，已经轮到下载时释放名额
+func (s *streamer) leaveQueue(q *queuedRecord) {
+	recordSlots.Lock()
+	defer recordSlots.Unlock()
+	if !removeQueued(q) {
+		select {
+		case <-q.ready:
+			delete(recordSlots.active, q.LiveID)
+			dispatchSlots()
+		default:
+		}
+	}
+}
+
+// 释放下载直播视频的名额
+func releaseRecordSlot(liveID string) {
+	recordSlots.Lock()
+	defer recordSlots.Unlock()
+	delete(recordSlots.active, liveID)
+	delete(recordSlots.preempted, liveID)
+	dispatchSlots()
+}
+
+// 取消指定主播的排队，返回是否有排队
+func cancelQueued(uid int) bool {
+	recordSlots.Lock()
+	defer recordSlots.Unlock()
+	var canceled bool
+	for _, q := range append([]*queuedRecord(nil), recordSlots.queue...) {
+		if q.UID == uid {
+			removeQueued(q)
+			close(q.cancel)
+			canceled = true
+		}
+	}
+	return canceled
+}
+
+// 列出排队等待下载的直播视频
+func listQueue() []queuedRecord {
+	recordSlots.Lock()
+	list := make([]queuedRecord, 0, len(recordSlots.queue))
+	for _, q := range recordSlots.queue {
+		list = append(list, *q)
+	}
+	recordSlots.Unlock()
+
+	if *isNoGUI {
+		log.Println("排队等待下载的直播视频：")
+		for _, q := range list {
+			log.Printf("%s（%d）：优先级 %d，开始排队的时间：%s", q.Name, q.UID, q.Priority, time.UnixMilli(q.Since).Format("2006-01-02 15:04:05"))
+		}
+	}
+	return list
+}
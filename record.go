@@ -7,14 +7,189 @@ import (
 	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
 
 const ffmpegNotExist = "没有找到 FFmpeg，停止下载直播视频"
 
+// 一场直播里直播源连续下载失败多少次后切换为另一种直播源
+const sourceFailoverThreshold = 3
+
+// 录播文件名后面还会加上分段合并、剪辑、sidecar 和后期处理等的后缀，限制文件名长度时为这些后缀预留的字节数
+const filenameSuffixRoom = 64
+
+// 转换文件名，转换后的文件名已经被使用时在 suffix 前加上序号，防止文件名模板没有 {time} 和 {part} 时覆盖之前的文件
+func uniqueFilename(filename, suffix string) string {
+	baseFile := transFilename(filename, suffix)
+	for i := 2; baseFile != "" && isFilenameUsed(baseFile); i++ {
+		baseFile = transFilename(filename, fmt.Sprintf(" (%d)", i)+suffix)
+	}
+	return baseFile
+}
+
+// 是否已经有以 baseFile 加上 . 开头的文件，比如录播、弹幕和元数据文件
+func isFilenameUsed(baseFile string) bool {
+	entries, err := os.ReadDir(filepath.Dir(baseFile))
+	if err != nil {
+		return false
+	}
+	prefix := filepath.Base(baseFile) + "."
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// 没有分段或停止下载时分段结束，下载到的数据少于 failedPartSize 字节或时长短于 failedPartDuration 也算作下载失败
+const (
+	failedPartSize     = 1024 * 1024
+	failedPartDuration = 30 * time.Second
+)
+
+// 下载直播视频的方式
+const (
+	autoRecorder    = "auto"    // hls 直播源使用内置下载器，flv 直播源有 FFmpeg 时使用 FFmpeg，没有时使用内置下载器
+	ffmpegRecorder  = "ffmpeg"  // 使用 FFmpeg 下载
+	nativeRecorder  = "native"  // 使用内置的 flv 或 hls 下载器下载，FFmpeg 只用来转换格式
+	commandRecorder = "command" // 使用 live.json 里设置的外部命令下载
+)
+
+// 根据 config.Recorder 和直播源类型 source 选择下载直播视频的方式，recorder 为空时无法下载
+func selectRecorder(source string) (recorder, ffmpegFile string) {
+	switch config.Recorder {
+	case ffmpegRecorder:
+		if ffmpegFile = getFFmpeg(); ffmpegFile == "" {
+			return "", ""
+		}
+		return ffmpegRecorder, ffmpegFile
+	case nativeRecorder:
+		return nativeRecorder, ""
+	default:
+		// 内置的 hls 下载器可以统计缺失的分片
+		if source == "hls" {
+			return nativeRecorder, ""
+		}
+		if ffmpegFile = getFFmpeg(); ffmpegFile == "" {
+			lPrintWarn("没有找到 FFmpeg，使用内置的 flv 下载器下载直播视频")
+			return nativeRecorder, ""
+		}
+		return ffmpegRecorder, ffmpegFile
+	}
+}
+
+// 选择下载主播的直播视频的方式，设置了外部命令时使用外部命令下载
+func (s *streamer) selectRecorder(source string) (recorder, ffmpegFile string) {
+	if len(s.Command) != 0 {
+		return commandRecorder, ""
+	}
+	return selectRecorder(source)
+}
+
+// 主播的直播源类型，live.json 里没有设置时使用 config.json 里的设置
+func (s *streamer) source() string {
+	if s.Source != "" {
+		return s.Source
+	}
+	return config.Source
+}
+
+// 另一种直播源类型
+func otherSource(source string) string {
+	if source == "hls" {
+		return "flv"
+	}
+	return "hls"
+}
+
+// 替换外部命令和 FFmpeg 参数里的占位符 {url}、{output}、{title} 和 {uid}
+func (s *streamer) expandArgs(args []string, url, output, title string) []string {
+	r := strings.NewReplacer(
+		"{url}", url,
+		"{output}", output,
+		"{title}", title,
+		"{uid}", strconv.Itoa(s.UID),
+	)
+	expanded := make([]string, len(args))
+	for i, arg := range args {
+		expanded[i] = r.Replace(arg)
+	}
+	return expanded
+}
+
+// 下载时使用的中间格式的后缀名，flv 和 ts 文件在程序崩溃后也能修复
+func intermediateExt(source string) string {
+	if source == "hls" {
+		return "ts"
+	}
+	return "flv"
+}
+
+// 使用 FFmpeg 将下载的中间格式文件无损转换为 format 格式，成功后删除原文件，返回最终的文件
+func remuxFile(inFile, format string) (string, error) {
+	if format == "" || "."+format == filepath.Ext(inFile) {
+		return inFile, nil
+	}
+	if info, err := os.Stat(inFile); err != nil || info.Size() == 0 {
+		return inFile, fmt.Errorf("文件 %s 不存在或为空，取消转换格式", inFile)
+	}
+
+	outFile := strings.TrimSuffix(inFile, filepath.Ext(inFile)) + "." + format
+	lPrintf("开始将 %s 转换为 %s", inFile, outFile)
+	if err := runFFmpeg("-i", inFile, "-c", "copy", outFile); err != nil {
+		_ = os.Remove(outFile)
+		return inFile, fmt.Errorf("将 %s 转换为 %s 失败，保留原文件：%w", inFile, outFile, err)
+	}
+	if err := os.Remove(inFile); err != nil {
+		lPrintErrf("删除文件 %s 失败：%v", inFile, err)
+	}
+	lPrintf("成功将 %s 转换为 %s", inFile, outFile)
+	return outFile, nil
+}
+
+// 使用 FFmpeg 从下载的文件中提取音频，format 为 opus 时转码为 opus，否则无损转换为 m4a，成功后删除原文件，返回最终的文件
+func extractAudio(inFile, format string) (string, error) {
+	if info, err := os.Stat(inFile); err != nil || info.Size() == 0 {
+		return inFile, fmt.Errorf("文件 %s 不存在或为空，取消提取音频", inFile)
+	}
+
+	args := []string{"-i", inFile, "-vn"}
+	if format == "opus" {
+		args = append(args, "-c:a", "libopus", "-b:a", "96k")
+	} else {
+		format = "m4a"
+		args = append(args, "-c:a", "copy")
+	}
+	outFile := strings.TrimSuffix(inFile, filepath.Ext(inFile)) + "." + format
+	lPrintf("开始从 %s 提取音频 %s", inFile, outFile)
+	if err := runFFmpeg(append(args, outFile)...); err != nil {
+		_ = os.Remove(outFile)
+		return inFile, fmt.Errorf("从 %s 提取音频 %s 失败，保留原文件：%w", inFile, outFile, err)
+	}
+	if err := os.Remove(inFile); err != nil {
+		lPrintErrf("删除文件 %s 失败：%v", inFile, err)
+	}
+	lPrintf("成功从 %s 提取音频 %s", inFile, outFile)
+	return outFile, nil
+}
+
 // 临时下载指定主播的直播视频
 func startRec(uid int, danmu bool) bool {
+	return startRecording(uid, danmu, false)
+}
+
+// 临时只下载指定主播的直播音频
+func startAudioRec(uid int) bool {
+	return startRecording(uid, false, true)
+}
+
+// 临时下载指定主播的直播视频，audio 为 true 时只下载音频，为 false 时按照 live.json 里的设置
+func startRecording(uid int, danmu, audio bool) bool {
 	var name string
 	s, ok := getStreamer(uid)
 	if !ok {
@@ -28,6 +203,9 @@ func startRec(uid int, danmu bool) bool {
 	s.Notify.NotifyRecord = true
 	s.Record = true
 	s.Danmu = danmu
+	if audio {
+		s.AudioOnly = true
+	}
 
 	liveID := getLiveID(uid)
 	if liveID == "" {
@@ -39,7 +217,7 @@ func startRec(uid int, danmu bool) bool {
 		return false
 	}
 
-	if ffmpegFile := getFFmpeg(); ffmpegFile == "" {
+	if recorder, _ := s.selectRecorder(s.source()); recorder == "" {
 		desktopNotify(ffmpegNotExist)
 		s.sendMirai(ffmpegNotExist, false)
 		return false
@@ -58,6 +236,9 @@ func startRec(uid int, danmu bool) bool {
 
 // 停止下载指定主播的直播视频
 func stopRec(uid int) bool {
+	if cancelQueued(uid) {
+		return true
+	}
 	infoList, ok := getLiveInfoByUID(uid)
 	if !ok {
 		lPrintWarnf("没有在下载 uid 为%d的主播的直播视频", uid)
@@ -67,13 +248,7 @@ func stopRec(uid int) bool {
 	for _, info := range infoList {
 		if info.isRecording {
 			lPrintf("开始停止下载%s的liveID为%s直播视频", longID(uid), info.LiveID)
-			info.recordCh <- stopRecord
-			_, _ = io.WriteString(info.ffmpegStdin, "q")
-			// 等待 20 秒强制停止下载，goroutine 是为了防止锁住时间过长
-			go func(cancel context.CancelFunc) {
-				time.Sleep(20 * time.Second)
-				cancel()
-			}(info.recordCancel)
+			stopLiveRecord(info, stopRecord)
 		}
 	}
 
@@ -92,7 +267,193 @@ func quitRec(liveID string) {
 	}
 }
 
-// 下载主播的直播视频
+// 准备下载直播视频，返回运行下载的函数，会设置 rec 里用来停止下载的 stdin 和下载的统计数据
+//
+// 外部命令和 FFmpeg 一样需要遵守以下约定：
+// 停止下载时会往 stdin 写入 q，命令需要在 20 秒内保存好 {output} 并退出，否则会被强制结束；
+// 命令退出后无论退出码是什么都视为这一段下载结束，主播还在直播时会重启下载。
+func (s *streamer) prepareRecord(ctx context.Context, info *liveInfo, rec *recording, ffmpegFile, title string) (run func() error) {
+	recordFile := rec.recordFile
+	if rec.recorder == commandRecorder {
+		args := s.expandArgs(s.Command, info.streamURL, recordFile, title)
+		cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+		hideCmdWindow(cmd)
+
+		stdin, err := cmd.StdinPipe()
+		checkErr(err)
+		rec.ffmpegStdin = stdin
+		if !*isListen {
+			cmd.Stdin = os.Stdin
+		}
+		return cmd.Run
+	}
+
+	if rec.recorder == ffmpegRecorder {
+		args := []string{
+			"-nostats",
+			"-progress", "pipe:1",
+			"-rw_timeout", "20000000",
+			"-timeout", "20000000",
+		}
+		args = append(args, s.expandArgs(s.FFmpegInputArgs, info.streamURL, recordFile, title)...)
+		args = append(args, "-i", info.streamURL, "-c", "copy")
+		if s.AudioOnly {
+			args = append(args, "-vn")
+		}
+		args = append(args, s.expandArgs(s.FFmpegOutputArgs, info.streamURL, recordFile, title)...)
+		args = append(args, recordFile)
+		cmd := exec.CommandContext(ctx, ffmpegFile, args...)
+		hideCmdWindow(cmd)
+		// 通过 stdout 获取下载进度
+		rec.progress = new(ffmpegProgress)
+		cmd.Stdout = rec.progress
+
+		stdin, err := cmd.StdinPipe()
+		checkErr(err)
+		rec.ffmpegStdin = stdin
+		if !*isListen {
+			// 程序单独下载一个直播视频时可以按 q 键退出（ffmpeg 的特性）
+			cmd.Stdin = os.Stdin
+		}
+		return cmd.Run
+	}
+
+	qw := newQuitWriter()
+	rec.ffmpegStdin = qw
+	if !*isListen {
+		go func() {
+			_, _ = io.Copy(qw, os.Stdin)
+		}()
+	}
+	if rec.source == "hls" {
+		stats := new(hlsStats)
+		rec.hlsStats = stats
+		hlsURL := info.hlsURL
+		return func() error {
+			return recordHLS(ctx, hlsURL, recordFile, qw.quit, stats, s.longID())
+		}
+	}
+	flvURL := info.flvURL
+	return func() error {
+		return recordFLV(ctx, flvURL, recordFile, qw.quit)
+	}
+}
+
+// 返回需要分段的原因，不需要分段时返回空字符串
+func (s *streamer) needSplit(recordFile, title string, start time.Time) string {
+	if s.SplitDuration > 0 && time.Since(start) >= time.Duration(s.SplitDuration)*time.Minute {
+		return fmt.Sprintf("录播时长超过%d分钟", s.SplitDuration)
+	}
+	if s.SplitSize > 0 {
+		if info, err := os.Stat(recordFile); err == nil && info.Size() >= int64(s.SplitSize)*1024*1024 {
+			return fmt.Sprintf("录播文件大小超过%dMB", s.SplitSize)
+		}
+	}
+	if s.SplitOnTitle {
+		if newTitle := s.getTitle(); newTitle != "" && newTitle != title {
+			return "直播间标题改为：" + newTitle
+		}
+	}
+	return ""
+}
+
+// 监控是否需要分段，需要时停止下载当前分段，返回的 channel 会收到分段的原因
+func (s *streamer) monitorSplit(ctx context.Context, rec *recording, title string) <-chan string {
+	ch := make(chan string, 1)
+	if s.SplitDuration <= 0 && s.SplitSize <= 0 && !s.SplitOnTitle {
+		return ch
+	}
+	start := time.Now()
+	go func() {
+		ticker := time.NewTicker(5 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if reason := s.needSplit(rec.recordFile, title, start); reason != "" {
+					lPrintf("%s，开始将%s的直播视频分段", reason, s.longID())
+					ch <- reason
+					rec.stop()
+					return
+				}
+			}
+		}
+	}()
+	return ch
+}
+
+// 已下载的大小，使用 FFmpeg 下载时取文件大小和 FFmpeg 进度里较大的一个
+func recordedSize(recordFile string, progress *ffmpegProgress) int64 {
+	var size int64
+	if info, err := os.Stat(recordFile); err == nil {
+		size = info.Size()
+	}
+	if progress != nil {
+		if p := progress.copy(); p.Bytes > size {
+			size = p.Bytes
+		}
+	}
+	return size
+}
+
+// 删除没有下载到数据的分段的录播、弹幕、元数据、封面和头像文件以及 recording.json 里的记录
+func discardPart(recordFile, assFile string) {
+	metaFile := sidecarFile(recordFile)
+	files := []string{recordFile, metaFile, assFile}
+	if sc, err := readSidecar(metaFile); err == nil {
+		for _, name := range []string{sc.Cover, sc.Avatar} {
+			if name != "" {
+				files = append(files, filepath.Join(filepath.Dir(metaFile), name))
+			}
+		}
+	}
+	for _, file := range files {
+		if file == "" {
+			continue
+		}
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			lPrintErrf("删除文件 %s 失败：%v", file, err)
+		}
+	}
+	removeJournal(recordFile)
+}
+
+// 监控下载是否卡住，超过 config.StallTimeout 秒没有下载到数据时停止下载当前分段以便重启下载，返回的 channel 会在卡住时收到数据
+func (s *streamer) monitorStall(ctx context.Context, rec *recording) <-chan struct{} {
+	ch := make(chan struct{}, 1)
+	if config.StallTimeout <= 0 {
+		return ch
+	}
+	timeout := time.Duration(config.StallTimeout) * time.Second
+	go func() {
+		ticker := time.NewTicker(5 * time.Second)
+		defer ticker.Stop()
+		lastSize, lastChange := int64(0), time.Now()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if size := recordedSize(rec.recordFile, rec.progress); size != lastSize {
+					lastSize, lastChange = size, time.Now()
+					continue
+				}
+				if time.Since(lastChange) < timeout {
+					continue
+				}
+				lPrintWarnf("%s的直播视频超过%d秒没有下载到数据，尝试重启下载", s.longID(), config.StallTimeout)
+				ch <- struct{}{}
+				rec.stop()
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// 下载主播的直播视频，直播视频可能会因为分段或意外结束而分为多个文件
 func (s streamer) recordLive(danmu bool) {
 	defer func() {
 		if err := recover(); err != nil {
@@ -104,8 +465,8 @@ func (s streamer) recordLive(danmu bool) {
 		}
 	}()
 
-	ffmpegFile := getFFmpeg()
-	if ffmpegFile == "" {
+	recorder, _ := s.selectRecorder(s.source())
+	if recorder == "" {
 		desktopNotify(ffmpegNotExist)
 		s.sendMirai(ffmpegNotExist, false)
 		return
@@ -134,18 +495,25 @@ func (s streamer) recordLive(danmu bool) {
 		info.streamURL = url
 	}
 
-	title := s.getTitle()
-	filename := getTime() + " " + s.Name + " " + title
-	recordFile := transFilename(filename)
-	if recordFile == "" {
+	// 同时下载的直播视频数量达到上限时排队等待
+	waited, ok := s.acquireRecordSlot(info.LiveID)
+	if !ok {
 		return
 	}
-	// 想要输出其他视频格式可以修改 config.json 里的 Output
-	recordFile = recordFile + "." + config.Output
-	info.recordFile = recordFile
+	defer releaseRecordSlot(info.LiveID)
+	if waited {
+		// 排队时直播源链接可能已经失效，重新获取
+		newInfo, err := s.getLiveInfo()
+		if err != nil || newInfo.LiveID != info.LiveID {
+			lPrintErrf("无法重新获取%s的直播源，取消下载直播视频：%v", s.longID(), err)
+			return
+		}
+		info.streamInfo = newInfo.streamInfo
+		info.streamURL = newInfo.streamURL
+	}
 
+	title := s.getTitle()
 	lPrintln("开始下载" + s.longID() + "的直播视频")
-	lPrintln("本次下载的视频文件保存在" + recordFile)
 	if *isListen {
 		lPrintf("如果想提前结束下载%s的直播视频，运行 stoprecord %d", s.longID(), s.UID)
 	}
@@ -159,65 +527,38 @@ func (s streamer) recordLive(danmu bool) {
 		}
 	}
 
-	// 运行 ffmpeg 下载直播视频，不用 mainCtx 是为了能正常退出
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-	cmd := exec.CommandContext(ctx, ffmpegFile,
-		"-rw_timeout", "20000000",
-		"-timeout", "20000000",
-		"-i", info.streamURL,
-		"-c", "copy", recordFile)
-	hideCmdWindow(cmd)
-
-	stdin, err := cmd.StdinPipe()
-	checkErr(err)
-	defer stdin.Close()
-	info.recordCh = make(chan control, 20)
-	info.ffmpegStdin = stdin
-	info.recordCancel = cancel
-	info.isRecording = true
-	setLiveInfo(info)
-	// 只运行一次
-	var once sync.Once
-	q := func() {
-		quitRec(info.LiveID)
-	}
-	defer once.Do(q)
-
+	liveID := info.LiveID
+	defer quitRec(liveID)
 	if !*isListen {
-		// 程序单独下载一个直播视频时可以按 q 键退出（ffmpeg 的特性）
-		cmd.Stdin = os.Stdin
 		lPrintln("按 q 键退出下载直播视频")
 	}
 
-	// 下载弹幕
-	if danmu {
-		go s.initDanmu(ctx, info.LiveID, filename)
-	}
-
-	err = cmd.Run()
-	if err != nil {
-		lPrintErrf("下载%s的直播视频出现错误，尝试重启下载：%v", s.longID(), err)
+	// 同时下载 live.json 里 extraQualities 的画质，这些画质不下载弹幕
+	var wg sync.WaitGroup
+	for _, q := range s.ExtraQualities {
+		wg.Add(1)
+		go func(q string) {
+			defer wg.Done()
+			defer func() {
+				if err := recover(); err != nil {
+					lPrintErr("Recovering from panic in recordParts(), the error is:", err)
+					lPrintErrf("下载%s的画质为%s的直播视频发生错误", s.longID(), q)
+				}
+			}()
+			es := s
+			es.Quality = []string{q}
+			es.recordParts(info, q, false)
+		}(q)
 	}
-	defer s.moveFile(recordFile)
 
-	// 取消弹幕下载
-	cancel()
-	time.Sleep(10 * time.Second)
+	degraded, part := s.recordParts(info, "", danmu)
+	wg.Wait()
 
-	if s.isLiveOnByPage() {
-		select {
-		case <-info.recordCh:
-		default:
-			if newLiveID := getLiveID(s.UID); newLiveID == info.LiveID && *isListen {
-				// 程序处于监听状态时重启下载，否则不重启
-				lPrintWarn("因意外结束下载" + s.longID() + "的直播视频，尝试重启下载")
-				once.Do(q)
-				go s.recordLive(danmu)
-			}
-		}
+	if degraded && danmu {
+		lPrintln("改为只下载" + s.longID() + "的直播弹幕")
+		// 使用下一个分段序号，防止覆盖最后一个分段的弹幕文件
+		go s.recordDanmu(mainCtx, liveID, s.getFilename(s.getTitle(), liveID, part+1))
 	}
-
 	lPrintln(s.longID() + "的直播视频下载已经结束")
 	if s.Notify.NotifyRecord {
 		if danmu {
@@ -229,3 +570,353 @@ func (s streamer) recordLive(danmu bool) {
 		}
 	}
 }
+
+// 分段下载直播视频，key 为 extraQualities 里的画质，为空时下载主画质，返回是否需要改为只下载弹幕和最后的分段序号
+func (s *streamer) recordParts(info liveInfo, key string, danmu bool) (degraded bool, part int) {
+	liveID := info.LiveID
+	// 直播源类型，连续下载失败时会切换为另一种
+	source := s.source()
+	recorder, ffmpegFile := s.selectRecorder(source)
+	logID := s.longID()
+	if key != "" {
+		logID += "的画质为" + key
+	}
+	recordCh := make(chan control, 20)
+	defer setRecording(info, key, nil)
+
+	// 上一个分段的弹幕下载结束时会关闭
+	danmuDone := make(chan struct{})
+	close(danmuDone)
+	// 开始下载这一段的原因和到这一段为止重启下载和分段的次数
+	reason, restarts, splits, stalls := reasonStart, 0, 0, 0
+	// 无法打开的直播源画质，重新获取直播源时会跳过
+	var failedQualities []string
+	// 是否已经保存直播间封面和头像
+	imagesSaved := false
+	// 这场直播里已经放弃的直播源类型和画质，key 为直播源类型加上空格和画质
+	exhausted := make(map[string]bool)
+	// 已经放弃的 src 直播源的画质
+	exhaustedQualities := func(src string) []string {
+		var qualities []string
+		for k := range exhausted {
+			if q, ok := strings.CutPrefix(k, src+" "); ok {
+				qualities = append(qualities, q)
+			}
+		}
+		return qualities
+	}
+	// src 直播源是否还有可以尝试的画质，extraQualities 的画质只能尝试现在的画质
+	hasCandidate := func(src string) bool {
+		if r, _ := s.selectRecorder(src); r == "" {
+			return false
+		}
+		if key != "" {
+			return !exhausted[src+" "+info.quality]
+		}
+		for _, stream := range info.StreamList {
+			if !exhausted[src+" "+stream.QualityName] {
+				return true
+			}
+		}
+		return false
+	}
+	// 连续下载失败的次数
+	failures := 0
+	// 等待合并的重启下载产生的分段，live.json 里的 mergeParts 为 true 时使用
+	var group []mergePart
+	var groupQuality string
+	takeGroup := func() func() {
+		g := group
+		group = nil
+		return func() { s.mergeParts(liveID, g) }
+	}
+	title := s.getTitle()
+loop:
+	for part = 1; ; part++ {
+		if free, low := isLowDiskSpace(); low {
+			if key == "" {
+				s.notifyLowDiskSpace(free)
+				degraded = true
+			}
+			break
+		}
+
+		if part > 1 || key != "" {
+			// 直播源链接可能已经失效，重新获取
+			newInfo, err := s.getLiveInfo(failedQualities...)
+			if err != nil || newInfo.LiveID != liveID {
+				lPrintErrf("无法重新获取%s的直播源，停止下载直播视频：%v", logID, err)
+				break
+			}
+			info.streamInfo = newInfo.streamInfo
+			title = s.getTitle()
+		}
+		info.streamURL = info.sourceURL(source)
+		if key != "" && !info.hasQuality(key) {
+			lPrintWarnf("%s的直播源没有画质%s，取消下载这个画质", s.longID(), key)
+			break
+		}
+		// 只合并画质相同的重启下载产生的分段
+		if len(group) > 0 && (reason != reasonRestart || info.quality != groupQuality) {
+			post := takeGroup()
+			if *isListen {
+				go post()
+			} else {
+				defer post()
+			}
+		}
+
+		filename := s.getFilename(title, liveID, part)
+		var suffix string
+		if key != "" {
+			suffix = "_" + key
+		}
+		baseFile := uniqueFilename(filename, suffix)
+		if baseFile == "" {
+			break
+		}
+		// 先下载为 flv 或 ts 文件，下载结束后再转换为 config.json 里的 Output 格式
+		recordFile := baseFile + "." + intermediateExt(source)
+		lPrintln("本次下载的视频文件保存在" + recordFile)
+		lPrintf("%s的直播源画质为%s，码率为%d", s.longID(), info.quality, info.bitrate)
+		var danmuFile string
+		if danmu {
+			danmuFile = baseFile + ".ass"
+		}
+		addJournal(s, liveID, recordFile, danmuFile)
+
+		metaFile := sidecarFile(recordFile)
+		sc := s.newSidecar(&info, title, part)
+		sc.Source = source
+		sc.Recorder = recorder
+		sc.Reason, sc.Restarts, sc.Splits, sc.Stalls = reason, restarts, splits, stalls
+		sc.FailedQualities = failedQualities
+		sc.Video = filepath.Base(recordFile)
+		if danmu {
+			sc.Danmu = filepath.Base(danmuFile)
+		}
+		writeSidecar(metaFile, sc)
+
+		// 运行 ffmpeg 或内置下载器下载直播视频，不用 mainCtx 是为了能正常退出
+		ctx, cancel := context.WithCancel(context.Background())
+		rec := &recording{
+			quality:      info.quality,
+			recordCh:     recordCh,
+			recordCancel: cancel,
+			recordFile:   recordFile,
+			recorder:     recorder,
+			source:       source,
+			start:        time.Now(),
+		}
+		run := s.prepareRecord(ctx, &info, rec, ffmpegFile, title)
+		// 先放进完整的 info，lInfoMap 里没有时 setRecording 会使用 info
+		updateLiveInfo(info, func(i *liveInfo) {
+			i.isRecording = true
+		})
+		setRecording(info, key, rec)
+
+		// 下载弹幕，需要等待上一个分段的弹幕下载结束
+		assFile := new(string)
+		if danmu {
+			prevDone := danmuDone
+			done := make(chan struct{})
+			danmuDone = done
+			go func() {
+				defer close(done)
+				<-prevDone
+				*assFile = s.initDanmu(ctx, liveID, baseFile)
+			}()
+		}
+
+		// 开始下载后再保存直播间封面和头像，防止下载图片太慢时错过直播开头
+		imagesDone := make(chan struct{})
+		if !imagesSaved && key == "" {
+			imagesSaved = true
+			go func() {
+				defer close(imagesDone)
+				cover, avatar := s.saveLiveImages(liveID, baseFile)
+				if cover != "" || avatar != "" {
+					updateSidecar(metaFile, func(sc *sidecar) {
+						sc.Cover, sc.Avatar = cover, avatar
+					})
+				}
+			}()
+		} else {
+			close(imagesDone)
+		}
+
+		splitCh := s.monitorSplit(ctx, rec, title)
+		stallCh := s.monitorStall(ctx, rec)
+		partStart := time.Now()
+		err := run()
+		// 没有下载到任何数据时认为无法打开这个画质的直播源
+		openFailed := err != nil && recordedSize(recordFile, rec.progress) == 0
+		if err != nil {
+			lPrintErrf("下载%s的直播视频出现错误：%v", logID, err)
+		}
+		duration := partDuration(rec, partStart)
+		// 没有分段或停止下载时，下载出错或者几乎没有下载到数据都算作下载失败，不管 FFmpeg 的退出码
+		tooShort := recordedSize(recordFile, rec.progress) < failedPartSize || duration < failedPartDuration
+		if (err != nil || tooShort) && len(recordCh) == 0 && len(splitCh) == 0 {
+			failures++
+		} else {
+			failures = 0
+		}
+		if rec.hlsStats != nil {
+			st := rec.hlsStats.copy()
+			lPrintf("%s的 hls 直播源下载了 %d 个分片，缺失 %d 个分片，不连续 %d 次，恢复下载 %d 次",
+				logID, st.Segments, st.Missing, st.Discontinuities, st.Resumes)
+		}
+		_ = rec.ffmpegStdin.Close()
+		// 取消弹幕下载
+		cancel()
+		// 等待封面和头像保存完再更新元数据
+		<-imagesDone
+		updateSidecar(metaFile, func(sc *sidecar) {
+			sc.End = time.Now().UnixMilli()
+			// 封面和头像会跟着没有下载到数据的分段一起删除，下一个分段重新保存
+			if recordedSize(recordFile, nil) == 0 && (sc.Cover != "" || sc.Avatar != "") {
+				imagesSaved = false
+			}
+			switch {
+			case len(recordCh) > 0:
+				sc.EndReason = reasonStop
+			case len(splitCh) > 0:
+				sc.EndReason = reasonSplit
+			case len(stallCh) > 0:
+				sc.EndReason = reasonStalled
+			default:
+				sc.EndReason = reasonInterrupted
+			}
+			if rec.hlsStats != nil {
+				sc.HLS = rec.hlsStats.copy()
+			}
+		})
+		// 等待这个分段的弹幕下载结束后再一起处理
+		partDone := danmuDone
+		switch {
+		case recordedSize(recordFile, nil) == 0:
+			lPrintWarnf("%s的这个分段没有下载到数据，删除这个分段的文件", logID)
+			discard := func() {
+				<-partDone
+				discardPart(recordFile, *assFile)
+			}
+			if *isListen {
+				go discard()
+			} else {
+				defer discard()
+			}
+		case s.MergeParts:
+			if len(group) == 0 {
+				groupQuality = info.quality
+			}
+			group = append(group, mergePart{
+				recordFile: recordFile,
+				assFile:    assFile,
+				done:       partDone,
+				duration:   duration,
+			})
+		default:
+			post := func() {
+				<-partDone
+				s.postRecord(liveID, recordFile, *assFile)
+			}
+			if *isListen {
+				// 后期处理可能需要一段时间，不能阻塞下载下一个分段
+				go post()
+			} else {
+				defer post()
+			}
+		}
+
+		select {
+		case c := <-recordCh:
+			// 手动停止下载，不再重启
+			degraded = c == danmuOnly
+		default:
+			select {
+			case <-splitCh:
+				reason = reasonSplit
+				splits++
+				continue
+			default:
+			}
+			// 在切换直播源或画质之前记录卡住，这些情况下重启的分段也要算作卡住
+			select {
+			case <-stallCh:
+				stalls++
+				addHistory(historyEntry{
+					Type:   historyStall,
+					UID:    s.UID,
+					Name:   s.Name,
+					LiveID: liveID,
+					Title:  title,
+					Video:  recordFile,
+				})
+			default:
+			}
+			// 这个直播源类型和画质连续下载失败或无法打开时放弃，这场直播里不再尝试
+			if failures >= sourceFailoverThreshold || (key == "" && openFailed) {
+				exhausted[source+" "+info.quality] = true
+			}
+			// 是否改为下载另一种直播源或另一个画质
+			switched := true
+			newSource := otherSource(source)
+			switch {
+			case failures >= sourceFailoverThreshold && hasCandidate(newSource):
+				r, f := s.selectRecorder(newSource)
+				lPrintWarnf("%s的 %s 直播源连续%d次下载失败，改为下载 %s 直播源", logID, source, failures, newSource)
+				source, recorder, ffmpegFile = newSource, r, f
+				reason = reasonFailover
+			case exhausted[source+" "+info.quality] && hasCandidate(source):
+				lPrintWarnf("无法下载%s的画质为%s的直播源，尝试下载下一个画质", s.longID(), info.quality)
+				reason = reasonRestart
+			case !hasCandidate(source) && !hasCandidate(newSource):
+				lPrintErrf("%s的所有直播源和画质都下载失败，停止下载直播视频", logID)
+				break loop
+			default:
+				switched = false
+				reason = reasonRestart
+			}
+			if switched {
+				failures = 0
+				// extraQualities 的画质不改为下载其他画质
+				if key == "" {
+					failedQualities = exhaustedQualities(source)
+				}
+			}
+			// 重启下载前都要等待并确认还在直播，防止一直失败时不停地重启
+			time.Sleep(10 * time.Second)
+			if !s.isLiveOnByPage() || getLiveID(s.UID) != liveID {
+				break
+			}
+			select {
+			case c := <-recordCh:
+				degraded = c == danmuOnly
+				break loop
+			default:
+			}
+			// 程序处于监听状态时才重启下载，改为下载其他直播源或画质不受限制
+			if !switched && !*isListen {
+				break
+			}
+			if !switched {
+				lPrintWarn("因意外结束下载" + logID + "的直播视频，尝试重启下载")
+			}
+			restarts++
+			continue
+		}
+		break
+	}
+
+	<-danmuDone
+	if len(group) > 0 {
+		post := takeGroup()
+		if *isListen {
+			go post()
+		} else {
+			post()
+		}
+	}
+	return degraded, part
+}
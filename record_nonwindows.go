@@ -3,9 +3,11 @@
 package main
 
 import (
+	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"strings"
 )
 
 // 查看并获取 FFmpeg 的位置
@@ -19,16 +21,46 @@ func getFFmpeg() (ffmpegFile string) {
 	return ffmpegFile
 }
 
-// 转换文件名和限制文件名长度，添加程序所在文件夹的路径
-func transFilename(filename string) string {
+// linux 和 macOS 下文件名的最大字节数
+const maxFilenameBytes = 255
+
+// 截断为不超过 n 字节，不会截断 UTF-8 字符
+func truncateBytes(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return strings.ToValidUTF8(s[:max(n, 0)], "")
+}
+
+// 转换文件名和限制文件名长度，添加程序所在文件夹的路径，filename 里的 / 为文件夹分隔符，会自动创建文件夹，
+// suffix 会加在文件名最后，限制文件名长度时不会被截断
+func transFilename(filename, suffix string) string {
 	// 转换文件名不允许的特殊字符
-	re := regexp.MustCompile(`[<>:"/\\|?*\r\n]`)
-	filename = re.ReplaceAllString(filename, "-")
-	// linux 和 macOS 下限制文件名长度
-	if len(filename) >= 250 {
-		filename = filename[:250]
+	re := regexp.MustCompile(`[<>:"\\|?*\r\n/]`)
+	var components []string
+	for _, c := range strings.Split(filename, "/") {
+		c = strings.TrimSpace(re.ReplaceAllString(c, "-"))
+		// 不允许跳出下载文件夹
+		if c == "" || c == "." || c == ".." {
+			continue
+		}
+		// linux 和 macOS 下限制文件夹名字长度
+		components = append(components, truncateBytes(c, maxFilenameBytes))
+	}
+	if len(components) == 0 {
+		lPrintErr("文件名不能为空，取消下载")
+		return ""
+	}
+	// 文件名要为 suffix 和之后加上的后缀预留长度
+	last := len(components) - 1
+	suffix = re.ReplaceAllString(suffix, "-")
+	components[last] = truncateBytes(components[last], maxFilenameBytes-filenameSuffixRoom-len(suffix)) + suffix
+	outFilename := filepath.Join(append([]string{*recordDir}, components...)...)
+	if err := os.MkdirAll(filepath.Dir(outFilename), 0755); err != nil {
+		lPrintErrf("创建文件夹 %s 失败，取消下载：%v", filepath.Dir(outFilename), err)
+		return ""
 	}
-	return filepath.Join(*recordDir, filename)
+	return outFilename
 }
 
 // Windows 下启用 GUI 时隐藏 FFmpeg 的 cmd 窗口
@@ -7,6 +7,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"strings"
 	"syscall"
 	"unicode/utf8"
 )
@@ -22,16 +23,45 @@ func getFFmpeg() (ffmpegFile string) {
 	return ffmpegFile
 }
 
-// 转换文件名和限制文件名长度，添加程序所在文件夹的路径
-func transFilename(filename string) string {
+// windows 下全路径文件名的最大长度
+const maxPathRunes = 255
+
+// 转换文件名和限制文件名长度，添加程序所在文件夹的路径，filename 里的 / 为文件夹分隔符，会自动创建文件夹，
+// suffix 会加在文件名最后，限制文件名长度时不会被截断
+func transFilename(filename, suffix string) string {
 	// 转换文件名不允许的特殊字符
-	re := regexp.MustCompile(`[<>:"/\\|?*\r\n]`)
-	filename = re.ReplaceAllString(filename, "-")
-	outFilename := filepath.Join(*recordDir, filename)
-	// windows 下全路径文件名不能过长
-	if utf8.RuneCountInString(outFilename) > 255 {
-		lPrintErr("全路径文件名太长，取消下载")
-		desktopNotify("全路径文件名太长，取消下载")
+	re := regexp.MustCompile(`[<>:"\\|?*\r\n/]`)
+	var components []string
+	for _, c := range strings.Split(filename, "/") {
+		// windows 下文件夹名字不能以空格或 . 结尾
+		c = strings.TrimRight(strings.TrimSpace(re.ReplaceAllString(c, "-")), ". ")
+		// 不允许跳出下载文件夹
+		if c == "" {
+			continue
+		}
+		components = append(components, c)
+	}
+	if len(components) == 0 {
+		lPrintErr("文件名不能为空，取消下载")
+		return ""
+	}
+	// windows 下全路径文件名不能过长，还要为 suffix 和之后加上的后缀预留长度，过长时截断文件名
+	last := len(components) - 1
+	suffix = re.ReplaceAllString(suffix, "-")
+	dir := filepath.Join(append([]string{*recordDir}, components[:last]...)...)
+	keep := maxPathRunes - filenameSuffixRoom - utf8.RuneCountInString(dir) - 1 - utf8.RuneCountInString(suffix)
+	if r := []rune(components[last]); len(r) > keep && keep > 0 {
+		components[last] = strings.TrimRight(string(r[:keep]), ". ")
+	}
+	if keep <= 0 || components[last] == "" {
+		lPrintErr("文件夹路径太长，取消下载")
+		desktopNotify("文件夹路径太长，取消下载")
+		return ""
+	}
+	components[last] += suffix
+	outFilename := filepath.Join(dir, components[last])
+	if err := os.MkdirAll(filepath.Dir(outFilename), 0755); err != nil {
+		lPrintErrf("创建文件夹 %s 失败，取消下载：%v", filepath.Dir(outFilename), err)
 		return ""
 	}
 	return outFilename
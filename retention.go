@@ -0,0 +1,243 @@
+// 录播保留规则相关
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 检查保留规则的时间间隔
+const retentionInterval = time.Hour
+
+// 录播保留规则，值为 0 时不限制
+type retention struct {
+	KeepSessions int `json:"keepSessions"` // 每个主播保留最近多少场直播的录播
+	KeepDays     int `json:"keepDays"`     // 保留最近多少天的录播
+	MaxSize      int `json:"maxSize"`      // 每个主播的录播总大小上限（MB），超过时删除最旧的直播的录播
+}
+
+// 一场直播的录播，由 directory 里的元数据文件找到
+type archivedSession struct {
+	UID    int      `json:"uid"`    // 主播 uid
+	Name   string   `json:"name"`   // 主播名字
+	LiveID string   `json:"liveID"` // 直播 ID
+	Title  string   `json:"title"`  // 直播间标题
+	Start  int64    `json:"start"`  // 开始下载的时间，是以毫秒为单位的 Unix 时间
+	End    int64    `json:"end"`    // 结束下载的时间，是以毫秒为单位的 Unix 时间
+	Size   int64    `json:"size"`   // 文件总大小（字节）
+	Reason string   `json:"reason"` // 删除的原因
+	Files  []string `json:"files"`  // 录播、弹幕、元数据和校验和文件
+}
+
+// 是否设置了保留规则
+func (r *retention) isSet() bool {
+	return r != nil && (r.KeepSessions > 0 || r.KeepDays > 0 || r.MaxSize > 0)
+}
+
+// 主播的保留规则
+func (s *streamer) getRetention() *retention {
+	if s.Retention != nil {
+		return s.Retention
+	}
+	return &config.Retention
+}
+
+// 元数据文件对应的所有文件
+func sessionFiles(meta string, sc *sidecar) []string {
+	dir := filepath.Dir(meta)
+	files := []string{meta}
+	for _, name := range []string{sc.Video, sc.Danmu, sc.Cover, sc.Avatar} {
+		if name == "" {
+			continue
+		}
+		file := filepath.Join(dir, name)
+		if isFileExist(file) {
+			files = append(files, file)
+		}
+		// checksum 步骤生成的校验和文件
+		for _, algorithm := range []string{"md5", "sha1", "sha256"} {
+			if isFileExist(file + "." + algorithm) {
+				files = append(files, file+"."+algorithm)
+			}
+		}
+	}
+	return files
+}
+
+// 查找 directory 里的所有录播，按主播 uid 和 liveID 分组，seen 用来跳过已经找到的元数据文件
+func scanSessions(directory string, sessions map[int]map[string]*archivedSession, seen map[string]bool) {
+	err := filepath.WalkDir(directory, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(path, ".json") {
+			return nil
+		}
+		if abs, err := filepath.Abs(path); err == nil {
+			if seen[abs] {
+				return nil
+			}
+			seen[abs] = true
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil
+		}
+		var sc sidecar
+		if err = json.Unmarshal(data, &sc); err != nil || sc.UID <= 0 || sc.LiveID == "" {
+			return nil
+		}
+		if sessions[sc.UID] == nil {
+			sessions[sc.UID] = make(map[string]*archivedSession)
+		}
+		as, ok := sessions[sc.UID][sc.LiveID]
+		if !ok {
+			as = &archivedSession{UID: sc.UID, Name: sc.Name, LiveID: sc.LiveID, Title: sc.Title, Start: sc.Start}
+			sessions[sc.UID][sc.LiveID] = as
+		}
+		if sc.Start < as.Start {
+			as.Start = sc.Start
+		}
+		if sc.End > as.End {
+			as.End = sc.End
+		}
+		for _, file := range sessionFiles(path, &sc) {
+			if info, err := os.Stat(file); err == nil {
+				as.Size += info.Size()
+			}
+			as.Files = append(as.Files, file)
+		}
+		return nil
+	})
+	if err != nil {
+		lPrintErrf("查找文件夹 %s 里的录播失败：%v", directory, err)
+	}
+}
+
+// 正在下载或处理的直播的 liveID
+func activeLiveIDs() map[string]bool {
+	ids := make(map[string]bool)
+	lInfoMap.RLock()
+	for liveID := range lInfoMap.info {
+		ids[liveID] = true
+	}
+	lInfoMap.RUnlock()
+	jobs.Lock()
+	for _, j := range jobs.list {
+		if j.Status == statusQueued || j.Status == statusRunning {
+			ids[j.LiveID] = true
+		}
+	}
+	jobs.Unlock()
+	return ids
+}
+
+// 返回按照保留规则需要删除的录播
+func expiredSessions() []archivedSession {
+	// 需要检查的文件夹
+	dirs := make(map[string]bool)
+	if config.Directory != "" && config.Retention.isSet() {
+		dirs[config.Directory] = true
+	}
+	for _, s := range getStreamers() {
+		if s.getRetention().isSet() {
+			if dir := s.directory(); dir != "" {
+				dirs[dir] = true
+			}
+		}
+	}
+	sessions := make(map[int]map[string]*archivedSession)
+	seen := make(map[string]bool)
+	for dir := range dirs {
+		scanSessions(dir, sessions, seen)
+	}
+
+	active := activeLiveIDs()
+	now := time.Now()
+	expired := []archivedSession{}
+	for uid, m := range sessions {
+		s, ok := getStreamer(uid)
+		if !ok {
+			s = streamer{UID: uid}
+		}
+		r := s.getRetention()
+		if !r.isSet() {
+			continue
+		}
+		list := make([]*archivedSession, 0, len(m))
+		for _, as := range m {
+			list = append(list, as)
+		}
+		// 最新的直播在前面
+		sort.Slice(list, func(i, j int) bool {
+			return list[i].Start > list[j].Start
+		})
+
+		var total int64
+		for i, as := range list {
+			total += as.Size
+			switch {
+			case active[as.LiveID]:
+				// 不删除正在下载或处理的直播的录播
+				continue
+			case r.KeepSessions > 0 && i >= r.KeepSessions:
+				as.Reason = fmt.Sprintf("超过保留的%d场直播", r.KeepSessions)
+			case r.KeepDays > 0 && now.Sub(time.UnixMilli(as.Start)) > time.Duration(r.KeepDays)*24*time.Hour:
+				as.Reason = fmt.Sprintf("超过保留的%d天", r.KeepDays)
+			case r.MaxSize > 0 && total > int64(r.MaxSize)*1024*1024:
+				as.Reason = fmt.Sprintf("录播总大小超过%dMB", r.MaxSize)
+				// 删除后不再计入总大小
+				total -= as.Size
+			default:
+				continue
+			}
+			expired = append(expired, *as)
+		}
+	}
+	sort.Slice(expired, func(i, j int) bool {
+		return expired[i].Start < expired[j].Start
+	})
+	return expired
+}
+
+// 预览按照保留规则需要删除的录播，不会删除文件
+func listRetention() []archivedSession {
+	return expiredSessions()
+}
+
+// 按照保留规则删除录播
+func applyRetention() {
+	defer func() {
+		if err := recover(); err != nil {
+			lPrintErr("Recovering from panic in applyRetention(), the error is:", err)
+			lPrintErr("按照保留规则删除录播时发生错误")
+		}
+	}()
+
+	for _, as := range expiredSessions() {
+		lPrintf("%s，删除%s的liveID为%s的录播：%s", as.Reason, longID(as.UID), as.LiveID, as.Title)
+		for _, file := range as.Files {
+			if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+				lPrintErrf("删除文件 %s 失败：%v", file, err)
+			}
+		}
+	}
+}
+
+// 循环按照保留规则删除录播
+func cycleRetention(ctx context.Context) {
+	ticker := time.NewTicker(retentionInterval)
+	defer ticker.Stop()
+	for {
+		applyRetention()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,111 @@
+// 按直播间标题和分类决定是否下载的规则相关
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+)
+
+// 规则类型
+const (
+	ruleInclude = "include" // 符合时下载
+	ruleExclude = "exclude" // 符合时不下载
+)
+
+// 规则匹配的内容
+const (
+	ruleFieldTitle    = "title"    // 直播间标题
+	ruleFieldCategory = "category" // 直播分类，格式为 主分类/次分类
+)
+
+// 下载规则
+type recordRule struct {
+	Type    string `json:"type"`    // 规则类型，有 include 和 exclude 两种
+	Field   string `json:"field"`   // 匹配的内容，有 title 和 category 两种，为空时为 title
+	Pattern string `json:"pattern"` // 正则表达式
+}
+
+// 编译好的正则表达式，key 为正则表达式
+var ruleRegexps sync.Map
+
+// 检查规则的格式
+func (r *recordRule) valid() error {
+	if r.Type != ruleInclude && r.Type != ruleExclude {
+		return fmt.Errorf("type 必须是 %s 或 %s", ruleInclude, ruleExclude)
+	}
+	if r.Field != "" && r.Field != ruleFieldTitle && r.Field != ruleFieldCategory {
+		return fmt.Errorf("field 必须是 %s 或 %s", ruleFieldTitle, ruleFieldCategory)
+	}
+	_, err := regexp.Compile(r.Pattern)
+	return err
+}
+
+// 规则的描述
+func (r *recordRule) String() string {
+	field := "标题"
+	if r.Field == ruleFieldCategory {
+		field = "分类"
+	}
+	if r.Type == ruleInclude {
+		return fmt.Sprintf("包含规则（%s匹配 %s）", field, r.Pattern)
+	}
+	return fmt.Sprintf("排除规则（%s匹配 %s）", field, r.Pattern)
+}
+
+// 是否符合规则
+func (r *recordRule) match(title, category string) bool {
+	if r.Field == ruleFieldCategory {
+		return matchPattern(r.Pattern, category)
+	}
+	return matchPattern(r.Pattern, title)
+}
+
+// 正则表达式为空时总是返回 true
+func matchPattern(pattern, s string) bool {
+	if pattern == "" {
+		return true
+	}
+	var re *regexp.Regexp
+	if v, ok := ruleRegexps.Load(pattern); ok {
+		re = v.(*regexp.Regexp)
+	} else {
+		var err error
+		re, err = regexp.Compile(pattern)
+		if err != nil {
+			return false
+		}
+		ruleRegexps.Store(pattern, re)
+	}
+	return re.MatchString(s)
+}
+
+// 按照下载规则判断是否下载，符合任意一个排除规则时不下载，有包含规则时需要符合其中一个才下载，reason 为判断的理由
+func (s *streamer) checkRecordRules(title, category string) (ok bool, reason string) {
+	if len(s.RecordRules) == 0 {
+		return true, ""
+	}
+	hasInclude := false
+	var included *recordRule
+	for i := range s.RecordRules {
+		r := &s.RecordRules[i]
+		if r.Type == ruleExclude {
+			if r.match(title, category) {
+				return false, fmt.Sprintf("直播间标题“%s”（分类：%s）符合%s", title, category, r)
+			}
+			continue
+		}
+		hasInclude = true
+		if included == nil && r.match(title, category) {
+			included = r
+		}
+	}
+	switch {
+	case included != nil:
+		return true, fmt.Sprintf("直播间标题“%s”（分类：%s）符合%s", title, category, included)
+	case hasInclude:
+		return false, fmt.Sprintf("直播间标题“%s”（分类：%s）不符合任何包含规则", title, category)
+	default:
+		return true, fmt.Sprintf("直播间标题“%s”（分类：%s）不符合任何排除规则", title, category)
+	}
+}
@@ -0,0 +1,160 @@
+// 录制时间段和最长下载时长相关
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// 检查录制时间段和下载时长的时间间隔
+const scheduleInterval = 30 * time.Second
+
+// 录制时间段
+type scheduleWindow struct {
+	Weekdays []int  `json:"weekdays"` // 星期几，0 为星期日，为空时为每天
+	Start    string `json:"start"`    // 开始时间，格式为 15:04
+	End      string `json:"end"`      // 结束时间，格式为 15:04，不大于开始时间时为跨越午夜，等于开始时间时为全天
+}
+
+// 开始下载的时间
+type captureStart struct {
+	since     time.Time // 第一次检查到在下载的时间
+	scheduled bool      // 开始下载时是否在录制时间段内，在录制时间段外手动开始的下载不会因为录制时间段停止
+}
+
+// 检查录制时间段的格式
+func (w *scheduleWindow) valid() bool {
+	if _, err := time.Parse("15:04", w.Start); err != nil {
+		return false
+	}
+	if _, err := time.Parse("15:04", w.End); err != nil {
+		return false
+	}
+	for _, d := range w.Weekdays {
+		if d < 0 || d > 6 {
+			return false
+		}
+	}
+	return true
+}
+
+// 时间 t 所在的一天里的某个时间
+func clockOn(t time.Time, clock string) time.Time {
+	c, _ := time.Parse("15:04", clock)
+	return time.Date(t.Year(), t.Month(), t.Day(), c.Hour(), c.Minute(), 0, 0, t.Location())
+}
+
+// 是否包含星期几
+func (w *scheduleWindow) hasWeekday(d time.Weekday) bool {
+	if len(w.Weekdays) == 0 {
+		return true
+	}
+	for _, day := range w.Weekdays {
+		if time.Weekday(day) == d {
+			return true
+		}
+	}
+	return false
+}
+
+// 时间 t 是否在录制时间段内，跨越午夜的时间段属于开始的那一天
+func (w *scheduleWindow) contains(t time.Time) bool {
+	start, end := clockOn(t, w.Start), clockOn(t, w.End)
+	switch {
+	case start.Equal(end):
+		return w.hasWeekday(t.Weekday())
+	case start.Before(end):
+		return w.hasWeekday(t.Weekday()) && !t.Before(start) && t.Before(end)
+	default:
+		// 跨越午夜
+		if !t.Before(start) {
+			return w.hasWeekday(t.Weekday())
+		}
+		return t.Before(end) && w.hasWeekday(t.AddDate(0, 0, -1).Weekday())
+	}
+}
+
+// 时间 t 是否在主播的录制时间段内，没有设置录制时间段时总是返回 true
+func (s *streamer) inSchedule(t time.Time) bool {
+	if len(s.Schedule) == 0 {
+		return true
+	}
+	for i := range s.Schedule {
+		if s.Schedule[i].contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// 停止下载直播视频和弹幕，并发送提醒
+func (s *streamer) stopCapture(info liveInfo, reason string) {
+	msg := fmt.Sprintf("%s，停止下载%s的直播视频和弹幕", reason, s.Name)
+	lPrintWarn(msg)
+	desktopNotify(msg)
+	s.sendMirai(msg, false)
+	if info.isRecording {
+		stopLiveRecord(info, stopRecord)
+	}
+	if info.isDanmu && info.danmuCancel != nil {
+		info.danmuCancel()
+	}
+}
+
+// 循环检查正在下载的直播是否超出录制时间段或最长下载时长
+func cycleSchedule(ctx context.Context) {
+	defer func() {
+		if err := recover(); err != nil {
+			lPrintErr("Recovering from panic in cycleSchedule(), the error is:", err)
+			lPrintErr("检查录制时间段时发生错误，停止检查录制时间段")
+		}
+	}()
+
+	starts := make(map[string]captureStart)
+	ticker := time.NewTicker(scheduleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			var captures []liveInfo
+			lInfoMap.RLock()
+			for _, info := range lInfoMap.info {
+				if info.isRecording || info.isDanmu {
+					captures = append(captures, info)
+				}
+			}
+			lInfoMap.RUnlock()
+
+			now := time.Now()
+			capturing := make(map[string]bool)
+			for _, info := range captures {
+				capturing[info.LiveID] = true
+				s, ok := getStreamer(info.uid)
+				if !ok {
+					continue
+				}
+				start, ok := starts[info.LiveID]
+				if !ok {
+					start = captureStart{since: now, scheduled: s.inSchedule(now)}
+					starts[info.LiveID] = start
+				}
+				switch {
+				case start.scheduled && !s.inSchedule(now):
+					s.stopCapture(info, "超出设置的录制时间段")
+					delete(starts, info.LiveID)
+				case s.MaxDuration > 0 && now.Sub(start.since) >= time.Duration(s.MaxDuration)*time.Minute:
+					s.stopCapture(info, fmt.Sprintf("下载时长超过%d分钟", s.MaxDuration))
+					delete(starts, info.LiveID)
+				}
+			}
+			for liveID := range starts {
+				if !capturing[liveID] {
+					delete(starts, liveID)
+				}
+			}
+		}
+	}
+}
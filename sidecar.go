@@ -0,0 +1,143 @@
+// 录播元数据文件相关
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 开始或结束下载一段录播的原因
+const (
+	reasonStart       = "start"       // 开始下载
+	reasonSplit       = "split"       // 分段下载
+	reasonRestart     = "restart"     // 意外结束后重启下载
+	reasonFailover    = "failover"    // 连续下载失败后切换直播源类型
+	reasonStop        = "stop"        // 手动停止下载
+	reasonInterrupted = "interrupted" // 意外结束或直播结束
+	reasonStalled     = "stalled"     // 超时没有下载到数据
+)
+
+// 直播源的画质
+type sidecarStream struct {
+	Name    string `json:"name"`    // 画质名字
+	Bitrate int    `json:"bitrate"` // 码率
+}
+
+// 和录播及弹幕文件放在一起的元数据，文件名为 录播文件名.json
+type sidecar struct {
+	UID             int             `json:"uid"`                       // 主播 uid
+	Name            string          `json:"name"`                      // 主播名字
+	LiveID          string          `json:"liveID"`                    // 直播 ID
+	Title           string          `json:"title"`                     // 直播间标题
+	LiveStartTime   int64           `json:"liveStartTime"`             // 直播开始的时间，是以毫秒为单位的 Unix 时间
+	Source          string          `json:"source"`                    // 直播源类型，有 hls 和 flv 两种
+	Recorder        string          `json:"recorder"`                  // 下载直播视频的方式
+	Quality         string          `json:"quality"`                   // 下载的直播源的画质
+	Bitrate         int             `json:"bitrate"`                   // 下载的直播源的码率
+	Streams         []sidecarStream `json:"streams"`                   // 直播源的所有画质
+	FailedQualities []string        `json:"failedQualities,omitempty"` // 到这一段为止无法打开而跳过的画质
+	Part            int             `json:"part"`                      // 分段序号，从 1 开始
+	Reason          string          `json:"reason"`                    // 开始下载这一段的原因，有 start、split、restart 和 failover
+	Restarts        int             `json:"restarts"`                  // 到这一段为止重启下载的次数
+	Splits          int             `json:"splits"`                    // 到这一段为止分段的次数
+	Stalls          int             `json:"stalls"`                    // 到这一段为止因超时没有下载到数据而重启下载的次数
+	Start           int64           `json:"start"`                     // 开始下载的时间，是以毫秒为单位的 Unix 时间
+	End             int64           `json:"end"`                       // 结束下载的时间，是以毫秒为单位的 Unix 时间，为 0 时还在下载
+	EndReason       string          `json:"endReason"`                 // 结束下载这一段的原因，有 stop、split、stalled 和 interrupted
+	Video           string          `json:"video"`                     // 录播文件名
+	Danmu           string          `json:"danmu"`                     // 弹幕文件名
+	Cover           string          `json:"cover,omitempty"`           // 直播间封面的文件名，只保存在第一段
+	Avatar          string          `json:"avatar,omitempty"`          // 主播头像的文件名，只保存在第一段
+	HLS             *hlsStats       `json:"hls,omitempty"`             // 内置 hls 下载器的统计数据
+	MergedParts     []string        `json:"mergedParts,omitempty"`     // 合并进这个文件的分段的录播文件名
+}
+
+// 读写元数据文件的锁
+var sidecarMutex sync.Mutex
+
+// 录播或弹幕文件对应的元数据文件
+func sidecarFile(file string) string {
+	return strings.TrimSuffix(file, filepath.Ext(file)) + ".json"
+}
+
+// 新建录播的元数据
+func (s *streamer) newSidecar(info *liveInfo, title string, part int) *sidecar {
+	sc := &sidecar{
+		UID:           s.UID,
+		Name:          s.Name,
+		LiveID:        info.LiveID,
+		Title:         title,
+		LiveStartTime: info.LiveStartTime,
+		Source:        s.source(),
+		Quality:       info.quality,
+		Bitrate:       info.bitrate,
+		Part:          part,
+		Start:         time.Now().UnixMilli(),
+	}
+	for _, stream := range info.StreamList {
+		sc.Streams = append(sc.Streams, sidecarStream{Name: stream.QualityName, Bitrate: stream.Bitrate})
+	}
+	return sc
+}
+
+// 写入元数据文件
+func writeSidecar(file string, sc *sidecar) {
+	sidecarMutex.Lock()
+	defer sidecarMutex.Unlock()
+	if err := saveSidecar(file, sc); err != nil {
+		lPrintErrf("写入元数据文件 %s 失败：%v", file, err)
+	}
+}
+
+// 保存元数据文件，需要锁住 sidecarMutex
+func saveSidecar(file string, sc *sidecar) error {
+	data, err := json.MarshalIndent(sc, "", "    ")
+	if err != nil {
+		return err
+	}
+	if err = os.WriteFile(file+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(file+".tmp", file)
+}
+
+// 读取元数据文件
+func readSidecar(file string) (*sidecar, error) {
+	sidecarMutex.Lock()
+	defer sidecarMutex.Unlock()
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	sc := new(sidecar)
+	if err = json.Unmarshal(data, sc); err != nil {
+		return nil, err
+	}
+	return sc, nil
+}
+
+// 修改元数据文件，文件不存在时不做任何事
+func updateSidecar(file string, f func(*sidecar)) {
+	sidecarMutex.Lock()
+	defer sidecarMutex.Unlock()
+	data, err := os.ReadFile(file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			lPrintErrf("读取元数据文件 %s 失败：%v", file, err)
+		}
+		return
+	}
+	sc := new(sidecar)
+	if err = json.Unmarshal(data, sc); err != nil {
+		lPrintErrf("元数据文件 %s 的内容不符合 json 格式：%v", file, err)
+		return
+	}
+	f(sc)
+	if err = saveSidecar(file, sc); err != nil {
+		lPrintErrf("写入元数据文件 %s 失败：%v", file, err)
+	}
+}
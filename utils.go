@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"maps"
 	"os"
 	"strconv"
 	"strings"
@@ -25,6 +26,7 @@ const (
 	stopCycle
 	stopRecord
 	quit
+	danmuOnly // 停止下载直播视频，改为只下载弹幕
 )
 
 // 主播的管道信息
@@ -44,18 +46,37 @@ type streamerInfo struct {
 // 直播信息
 type liveInfo struct {
 	streamInfo
-	uid          int                // 主播的 uid
-	streamURL    string             // 直播源链接
-	isRecording  bool               // 是否正在下载直播
-	isDanmu      bool               // 是否正在下载直播弹幕
-	isKeepOnline bool               // 是否正在直播间挂机
+	uid          int                   // 主播的 uid
+	streamURL    string                // 直播源链接
+	isRecording  bool                  // 是否正在下载直播
+	isDanmu      bool                  // 是否正在下载直播弹幕
+	isKeepOnline bool                  // 是否正在直播间挂机
+	recordings   map[string]*recording // 正在下载的直播视频，key 为 live.json 里 extraQualities 的画质，主画质为空字符串
+	danmuCancel  context.CancelFunc    // 用来停止下载弹幕
+	onlineCancel context.CancelFunc    // 用来停止直播间挂机
+	assFile      string                // 弹幕文件路径
+}
+
+// 正在下载的直播视频，同一场直播可以同时下载多个画质
+type recording struct {
+	quality      string             // 下载的直播源画质
 	recordCh     chan control       // 控制录播的管道
 	ffmpegStdin  io.WriteCloser     // ffmpeg 的 stdin
 	recordCancel context.CancelFunc // 用来强行停止 ffmpeg 运行
-	danmuCancel  context.CancelFunc // 用来停止下载弹幕
-	onlineCancel context.CancelFunc // 用来停止直播间挂机
 	recordFile   string             // 录播文件路径
-	assFile      string             // 弹幕文件路径
+	recorder     string             // 下载直播视频的方式
+	source       string             // 直播源类型
+	hlsStats     *hlsStats          // 内置 hls 下载器的统计数据
+	progress     *ffmpegProgress    // FFmpeg 的下载进度
+	start        time.Time          // 开始下载这一段的时间
+	stopping     atomic.Bool        // 是否已经要求停止下载，正在等待下载器退出
+}
+
+// 要求下载器停止下载当前分段，20 秒后还没有停止时强制停止
+func (rec *recording) stop() {
+	rec.stopping.Store(true)
+	_, _ = io.WriteString(rec.ffmpegStdin, "q")
+	time.AfterFunc(20*time.Second, rec.recordCancel)
 }
 
 // 直播源信息
@@ -63,6 +84,8 @@ type streamInfo struct {
 	acfundanmu.StreamInfo        // 直播源信息
 	hlsURL                string // hls 直播源
 	flvURL                string // flv 直播源
+	quality               string // 下载的直播源的画质
+	bitrate               int    // 下载的直播源的码率
 	cfg                   acfundanmu.SubConfig
 }
 
@@ -137,6 +160,39 @@ func getTime() string {
 	return fmt.Sprintf("%d-%02d-%02d %02d-%02d-%02d", t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second())
 }
 
+// 根据文件名模板生成录播和弹幕的文件名（不包括后缀名），模板里的 / 为文件夹分隔符
+func (s *streamer) getFilename(title, liveID string, part int) string {
+	tmpl := config.FilenameTemplate
+	if s.FilenameTemplate != "" {
+		tmpl = s.FilenameTemplate
+	}
+	if tmpl == "" {
+		tmpl = defaultFilenameTemplate
+	}
+	// 占位符的值不能包含文件夹分隔符
+	escape := strings.NewReplacer("/", "-", "\\", "-").Replace
+	date, clock, _ := strings.Cut(getTime(), " ")
+	r := strings.NewReplacer(
+		"{uid}", strconv.Itoa(s.UID),
+		"{name}", escape(s.Name),
+		"{title}", escape(title),
+		"{liveID}", escape(liveID),
+		"{date}", date,
+		"{time}", clock,
+		"{part}", strconv.Itoa(part),
+	)
+	return r.Replace(tmpl)
+}
+
+// 返回命令输出的最后 n 行
+func lastLines(out []byte, n int) string {
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
+
 // 获取时间，按照 log 的时间格式
 func getLogTime() string {
 	t := time.Now()
@@ -228,6 +284,69 @@ func setLiveInfo(info liveInfo) {
 	lInfoMap.info[info.LiveID] = info
 }
 
+// 在锁住 lInfoMap 的情况下修改 info.LiveID 对应的 liveInfo，lInfoMap 里没有时使用 info
+func updateLiveInfo(info liveInfo, f func(*liveInfo)) {
+	lInfoMap.Lock()
+	defer lInfoMap.Unlock()
+	if i, ok := lInfoMap.info[info.LiveID]; ok {
+		info = i
+	}
+	f(&info)
+	lInfoMap.info[info.LiveID] = info
+}
+
+// 设置 info.LiveID 对应的直播的 key 画质的 recording，rec 为 nil 时删除，lInfoMap 里没有时使用 info，
+// 为了能在不锁住 lInfoMap 时读取 recordings，每次都会复制一个新的 map
+func setRecording(info liveInfo, key string, rec *recording) {
+	lInfoMap.Lock()
+	defer lInfoMap.Unlock()
+	liveID := info.LiveID
+	if i, ok := lInfoMap.info[liveID]; ok {
+		info = i
+	} else if rec == nil {
+		return
+	}
+	recordings := maps.Clone(info.recordings)
+	if recordings == nil {
+		recordings = make(map[string]*recording)
+	}
+	if rec == nil {
+		delete(recordings, key)
+	} else {
+		recordings[key] = rec
+	}
+	info.recordings = recordings
+	lInfoMap.info[liveID] = info
+}
+
+// 是否所有画质的下载都已经在停止
+func (info *liveInfo) isStopping() bool {
+	for _, rec := range info.recordings {
+		if !rec.stopping.Load() {
+			return false
+		}
+	}
+	return len(info.recordings) > 0
+}
+
+// 直播源类型对应的直播源链接
+func (info *liveInfo) sourceURL(source string) string {
+	if source == "hls" {
+		return info.hlsURL
+	}
+	return info.flvURL
+}
+
+// 直播源是否有画质 q
+func (info *liveInfo) hasQuality(q string) bool {
+	for _, stream := range info.StreamList {
+		if stream.QualityName == info.quality && matchQuality(stream, q) {
+			return true
+		}
+	}
+	return false
+}
+
 // 根据 liveID 查询是否正在下载直播视频
 func isRecording(liveID string) bool {
 	if info, ok := getLiveInfo(liveID); ok {
@@ -329,3 +448,9 @@ func acfun_cookies() acfundanmu.Cookies {
 
 	return nil
 }
+
+// 文件是否存在
+func isFileExist(file string) bool {
+	_, err := os.Stat(file)
+	return err == nil
+}
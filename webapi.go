@@ -17,6 +17,12 @@ import (
 const webHelp = `/listlive：列出正在直播的主播
 /listrecord：列出正在下载的直播视频
 /listdanmu：列出正在下载的直播弹幕
+/listqueue：列出排队等待下载的直播视频
+/listjob：列出录播的后期处理任务及其各步骤的状态
+/listretention：预览按照保留规则将会删除的录播，不会删除文件
+/history?uid=uid&from=开始日期&to=结束日期：查看开播、下播、录播文件和 QQ 通知的历史记录，参数都是可选的，日期格式为 2006-01-02
+/cover/uid：查看指定主播正在直播的直播间封面
+/avatar/uid：查看指定主播正在直播时的头像
 /startwebui：启动 web UI 服务器
 /stopwebui：停止 web UI 服务器
 /liststreamer：列出设置了开播提醒或自动下载直播的主播
@@ -47,6 +53,8 @@ const webHelp = `/listlive：列出正在直播的主播
 /stopdanmu/uid：正在下载指定主播的直播弹幕时取消下载
 /startrecdan/uid：临时下载指定主播的直播视频和弹幕，如果没有设置自动下载该主播的直播视频和弹幕，这次为一次性的下载
 /stoprecdan/uid：正在下载指定主播的直播视频和弹幕时取消下载
+/startaudio/uid：临时只下载指定主播的直播音频，这次为一次性的下载，用 /stoprecord/uid 取消下载
+/clip/uid/分钟数：无损剪辑指定主播正在下载的录播的最后几分钟，保存为单独的文件
 /log：查看 log
 /quit：退出本程序，退出需要等待半分钟左右
 /help：本帮助信息`
@@ -100,6 +108,64 @@ func cmdQQHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// 处理 "/history"
+func historyHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	args := []string{"0"}
+	if uid := q.Get("uid"); uid != "" {
+		args[0] = uid
+	}
+	from, to := q.Get("from"), q.Get("to")
+	switch {
+	case to != "":
+		if from == "" {
+			from = "1970-01-01"
+		}
+		args = append(args, from, to)
+	case from != "":
+		args = append(args, from)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if s := handleHistory(args); s != "" {
+		fmt.Fprint(w, s)
+	} else {
+		fmt.Fprint(w, "null")
+	}
+}
+
+// 处理 "/cover/uid" 和 "/avatar/uid"
+func imageHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	uid, err := atoi(vars["uid"])
+	checkErr(err)
+	img := getLiveImage(uid)
+	if img == nil {
+		http.Error(w, fmt.Sprintf("uid 为%d的主播不在直播", uid), http.StatusNotFound)
+		return
+	}
+	data := img.cover
+	if vars["image"] == "avatar" {
+		data = img.avatar
+	}
+	if len(data) == 0 {
+		http.Error(w, "无法获取图片", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", http.DetectContentType(data))
+	_, _ = w.Write(data)
+}
+
+// 处理 "/clip/uid/minutes"
+func clipHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	w.Header().Set("Content-Type", "application/json")
+	if s := handleClip([]string{vars["uid"], vars["minutes"]}); s != "" {
+		fmt.Fprint(w, s)
+	} else {
+		fmt.Fprint(w, "null")
+	}
+}
+
 // 显示 favicon
 func faviconHandler(w http.ResponseWriter, r *http.Request) {
 	http.ServeFile(w, r, logoFile)
@@ -144,6 +210,9 @@ func webAPI() {
 	r.HandleFunc("/favicon.ico", faviconHandler)
 	r.HandleFunc("/log", logHandler)
 	r.HandleFunc("/help", helpHandler)
+	r.HandleFunc("/history", historyHandler)
+	r.HandleFunc("/{image:cover|avatar}/{uid:[1-9][0-9]*}", imageHandler)
+	r.HandleFunc("/clip/{uid:[1-9][0-9]*}/{minutes:[1-9][0-9]*}", clipHandler)
 	r.HandleFunc("/", helpHandler)
 	r.HandleFunc("/{cmd}", cmdHandler)
 	r.HandleFunc("/{cmd}/{uid:[1-9][0-9]*}", cmdUIDHandler)